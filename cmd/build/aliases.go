@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// pageAlias is an alternate path for a page, read from the 'aliases' key in
+// the page's frontmatter, at which we generate a redirect to the page.
+type pageAlias struct {
+	// relPath is the path of the redirect file, relative to the output
+	// directory.
+	relPath string
+	// target is the URL of the page being redirected to.
+	target string
+	// src is the markdown file that declared the alias.
+	src string
+}
+
+var aliasTemplate = template.Must(template.New("alias").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="UTF-8">
+  <title>Redirecting&hellip;</title>
+  <link rel="canonical" href="{{ . }}">
+  <meta http-equiv="refresh" content="0; url={{ . }}">
+</head>
+<body>
+  <p>This page has moved to <a href="{{ . }}">{{ . }}</a>.</p>
+</body>
+</html>
+`))
+
+// parseAliases returns the aliases declared in a page's frontmatter, which
+// redirect to the given target URL.
+func parseAliases(metaData map[string]any, src, target string) ([]pageAlias, error) {
+	raw, ok := metaData["aliases"]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := raw.([]any)
+	if !ok {
+		return nil, fmt.Errorf("'aliases' must be a list, not %T", raw)
+	}
+
+	var ret []pageAlias
+	for _, item := range list {
+		alias, ok := item.(string)
+		if !ok || alias == "" {
+			return nil, fmt.Errorf("invalid alias %v; aliases must be non-empty strings", item)
+		}
+
+		// Aliases are site-absolute paths; a trailing slash means the
+		// directory index.
+		p := strings.TrimPrefix(path.Clean("/"+alias), "/")
+		switch {
+		case p == "" || strings.HasSuffix(alias, "/"):
+			p = path.Join(p, "index.html")
+		case path.Ext(p) == "" && *withExtensions:
+			p += ".html"
+		}
+		ret = append(ret, pageAlias{
+			relPath: filepath.FromSlash(p),
+			target:  target,
+			src:     src,
+		})
+	}
+	return ret, nil
+}
+
+// writeAliases writes a redirect file for each alias. outputs is the set of
+// files produced by the rest of the build; an alias that would overwrite one
+// of them is an error.
+func writeAliases(out outputWriter, aliases []pageAlias, outputs map[string]string) []error {
+	var errs []error
+	seen := make(map[string]string)
+	for _, a := range aliases {
+		if other, ok := outputs[a.relPath]; ok {
+			errs = append(errs, &buildError{
+				File: a.src,
+				Err:  fmt.Errorf("alias %s collides with %s", a.relPath, other),
+			})
+			continue
+		}
+		if other, ok := seen[a.relPath]; ok {
+			errs = append(errs, &buildError{
+				File: a.src,
+				Err:  fmt.Errorf("alias %s is also declared by %s", a.relPath, other),
+			})
+			continue
+		}
+		seen[a.relPath] = a.src
+
+		var buf bytes.Buffer
+		if err := aliasTemplate.Execute(&buf, a.target); err != nil {
+			errs = append(errs, &buildError{File: a.src, Err: err})
+			continue
+		}
+
+		log.Printf("writing alias %s -> %s", a.relPath, a.target)
+		if err := writeOutputBytes(out, a.relPath, buf.Bytes()); err != nil {
+			errs = append(errs, &buildError{File: a.src, Err: err})
+		}
+	}
+	return errs
+}
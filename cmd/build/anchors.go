@@ -0,0 +1,30 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// anchorsName is the name of the index of anchors written with -anchors.
+const anchorsName = "anchors.json"
+
+// anchorsJSON returns a JSON object mapping the URL of every page to the
+// sorted IDs of its headings, which can be linked to as fragments (e.g.
+// "/docs/setup.html#install").
+func anchorsJSON(pages []*page) ([]byte, error) {
+	index := make(map[pageURL][]string, len(pages))
+	for _, p := range pages {
+		ids := slices.Clone(p.anchors)
+		slices.Sort(ids)
+		index[p.ref.URL] = slices.Compact(ids)
+		if index[p.ref.URL] == nil {
+			index[p.ref.URL] = []string{}
+		}
+	}
+	// Maps are marshalled with their keys sorted.
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"gopkg.in/yaml.v2"
+)
+
+// authorLayout is the layout that author pages are rendered with.
+const authorLayout = "author"
+
+// authorInfo is an author's entry in the -authors file.
+type authorInfo struct {
+	Bio     string `yaml:"bio"`
+	Website string `yaml:"website"`
+	// Params holds any other keys.
+	Params map[string]any `yaml:",inline"`
+}
+
+// author is an author of one or more pages, as seen by templates: each page's
+// .Authors, .Site.Authors, and .Author on an author page.
+type author struct {
+	// Name is the author's name, as written in pages' frontmatter.
+	Name string
+	// Slug is the name of the author's page, without the extension.
+	Slug string
+	// URL is the site-absolute URL of the author's page, or empty if author
+	// pages aren't generated.
+	URL pageURL
+	// Bio, Website and Params are from the author's entry in the -authors
+	// file, if any.
+	Bio     string
+	Website string
+	Params  map[string]any
+	// Pages are the author's pages, in the same order as .Site.Pages.
+	Pages []*pageRef
+}
+
+// loadAuthors loads the -authors file, which maps each author's name to
+// information about them. It returns nil if fpath is empty.
+func loadAuthors(fpath string) (map[string]authorInfo, error) {
+	if fpath == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	var authors map[string]authorInfo
+	if err := yaml.Unmarshal(data, &authors); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", fpath, err)
+	}
+	return authors, nil
+}
+
+// authorSlug returns the slug for an author's name, e.g. "jane-doe" for
+// "Jane Doe".
+func authorSlug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
+
+// pageAuthors returns the authors of a page, from its 'author' and 'authors'
+// frontmatter keys.
+func (g *mdGenerator) pageAuthors(metaData map[string]any, src string) ([]*author, error) {
+	var names []string
+	for _, key := range []string{"author", "authors"} {
+		list, err := g.metaStringList(metaData, key, src)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, list...)
+	}
+
+	var ret []*author
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || slices.ContainsFunc(ret, func(a *author) bool { return a.Name == name }) {
+			continue
+		}
+		a, err := g.author(name)
+		if err != nil {
+			return nil, &buildError{File: src, Err: err}
+		}
+		ret = append(ret, a)
+	}
+	return ret, nil
+}
+
+// author returns the author with the given name, creating it on first use.
+func (g *mdGenerator) author(name string) (*author, error) {
+	if a, ok := g.authors[name]; ok {
+		return a, nil
+	}
+	slug := authorSlug(name)
+	if slug == "" {
+		return nil, fmt.Errorf("author %q has no letters or digits to name their page with", name)
+	}
+	for _, other := range g.authors {
+		if other.Slug == slug {
+			return nil, fmt.Errorf("authors %q and %q have the same page name %q", other.Name, name, slug)
+		}
+	}
+
+	info := g.authorInfo[name]
+	a := &author{
+		Name:    name,
+		Slug:    slug,
+		Bio:     info.Bio,
+		Website: info.Website,
+		Params:  info.Params,
+	}
+	if g.authorPages {
+		a.URL = pageURL("/" + g.linkPath(g.authorPath(a)))
+	}
+	if g.authors == nil {
+		g.authors = make(map[string]*author)
+	}
+	g.authors[name] = a
+	return a, nil
+}
+
+// authorPath returns the path of a's page in the output. With
+// -trailing-slash=always, it's an index page, so that the page's link (e.g.
+// /authors/jane-doe/) resolves to it.
+func (g *mdGenerator) authorPath(a *author) string {
+	if g.urls.trailingSlash == "always" {
+		return path.Join("authors", a.Slug, "index.html")
+	}
+	return path.Join("authors", a.Slug+".html")
+}
+
+// collectAuthors adds each page to its authors' pages, and returns all of the
+// authors sorted by name.
+func (g *mdGenerator) collectAuthors(pages []*pageRef) []*author {
+	for _, p := range pages {
+		for _, a := range p.Authors {
+			a.Pages = append(a.Pages, p)
+		}
+	}
+	return slices.SortedFunc(maps.Values(g.authors), func(a, b *author) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+}
+
+// writeAuthorPages renders a page for each author with the author layout,
+// and returns the files that it wrote, mapped to the author of each.
+func (g *mdGenerator) writeAuthorPages(site *siteData) (map[string]string, []error) {
+	written := make(map[string]string)
+	var errs []error
+	for _, a := range site.Authors {
+		relPath := g.authorPath(a)
+		data := renderData{
+			Title:   a.Name,
+			Path:    g.linkPath(relPath),
+			Charset: g.charset.name,
+			Site:    site,
+			Author:  a,
+			urls:    g.urls,
+		}
+		if g.urls.baseURL != "" {
+			data.Canonical = g.urls.absPageURL(a.URL)
+		}
+		if err := g.writePage(authorLayout, relPath, data, g.charset, time.Time{}); err != nil {
+			if file, ok := g.tmpls.paths[authorLayout]; ok {
+				err = attributeError(file, err)
+			}
+			errs = append(errs, fmt.Errorf("author page for %q: %w", a.Name, err))
+			continue
+		}
+		written[relPath] = a.Name
+	}
+	return written, errs
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// detectBaseURL guesses the URL that the site is served from for
+// -base-url=auto, and returns it with a description of where it came from,
+// or "" if it can't tell. It tries, in order:
+//
+//   - the environment variables in envVars, such as $CI_PAGES_URL, using
+//     the first that's set to a valid URL; invalid values are logged and
+//     skipped, since they may be set for something else;
+//   - a GitHub Pages CNAME file in staticDir;
+//   - the GitHub Pages URL for the git remote 'origin' of sourceDir.
+func detectBaseURL(envVars []string, staticDir, sourceDir string) (baseURL, source string) {
+	for _, name := range envVars {
+		v := strings.TrimSpace(os.Getenv(name))
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "://") {
+			// e.g. Vercel's $VERCEL_URL is just a host name.
+			v = "https://" + v
+		}
+		if err := checkBaseURL(v); err != nil {
+			log.Printf("warning: ignoring $%s for the base URL: %v", name, err)
+			continue
+		}
+		return withSlash(v), "$" + name
+	}
+
+	if staticDir != "" {
+		if b, err := os.ReadFile(filepath.Join(staticDir, "CNAME")); err == nil {
+			if host := strings.TrimSpace(string(b)); host != "" {
+				return "https://" + host + "/", "the CNAME file"
+			}
+		}
+	}
+
+	if sourceDir != "" {
+		out, err := exec.Command("git", "-C", sourceDir, "config", "--get", "remote.origin.url").Output()
+		if err == nil {
+			if u := githubPagesURL(string(bytes.TrimSpace(out))); u != "" {
+				return u, "the git remote 'origin'"
+			}
+		}
+	}
+	return "", ""
+}
+
+// githubRemoteRe matches the URL of a GitHub repository, as an SSH or HTTPS
+// remote, capturing the owner and repository name.
+var githubRemoteRe = regexp.MustCompile(`^(?:git@github\.com:|(?:https|ssh|git)://(?:[^@/]+@)?github\.com/)([^/]+)/([^/]+?)(?:\.git)?/?$`)
+
+// githubPagesURL returns the GitHub Pages URL of the repository with the
+// given remote URL, or "" if it's not a GitHub repository.
+func githubPagesURL(remote string) string {
+	m := githubRemoteRe.FindStringSubmatch(remote)
+	if m == nil {
+		return ""
+	}
+	owner, repo := strings.ToLower(m[1]), m[2]
+	if strings.EqualFold(repo, owner+".github.io") {
+		return fmt.Sprintf("https://%s.github.io/", owner)
+	}
+	return fmt.Sprintf("https://%s.github.io/%s/", owner, repo)
+}
+
+// withSlash returns u with a trailing slash.
+func withSlash(u string) string {
+	if strings.HasSuffix(u, "/") {
+		return u
+	}
+	return u + "/"
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// breadcrumb is one step in the trail from the home page to a page, as in
+// .Breadcrumbs.
+type breadcrumb struct {
+	// Title is the title of the section's index page, or a title made
+	// from the directory's name if it has none.
+	Title string
+	// URL is the site-absolute URL of the section's index page, or empty
+	// if it has none.
+	URL pageURL
+	// Current is true for the last crumb, which is the page itself.
+	Current bool
+
+	// relPath is the output path of the section's index page.
+	relPath string
+}
+
+// breadcrumbs returns the trail for the page with the output path relPath
+// and the given title: the home page, each enclosing section, and then the
+// page itself. The sections' titles and URLs are placeholders until
+// resolveBreadcrumbs is called.
+func (g *mdGenerator) breadcrumbs(relPath, title string) []breadcrumb {
+	relPath = filepath.ToSlash(relPath)
+	dir := path.Dir(relPath)
+	// indexPath is the output path of the index page of the directory d.
+	indexPath := func(d string) string {
+		return filepath.ToSlash(pageOutputPath(path.Join(d, "index.md")))
+	}
+
+	crumbs := []breadcrumb{{Title: "Home", relPath: indexPath(".")}}
+	if dir != "." {
+		var sofar string
+		for _, name := range strings.Split(dir, "/") {
+			sofar = path.Join(sofar, name)
+			crumbs = append(crumbs, breadcrumb{Title: titleize(name), relPath: indexPath(sofar)})
+		}
+	}
+	if relPath != indexPath(dir) {
+		crumbs = append(crumbs, breadcrumb{relPath: relPath})
+	}
+
+	// The last crumb is the page itself, whose title we already know.
+	last := &crumbs[len(crumbs)-1]
+	last.Current = true
+	if title != "" {
+		last.Title = title
+	}
+	return crumbs
+}
+
+// resolveBreadcrumbs fills in the title and URL of each crumb in every page's
+// breadcrumbs from the section index pages, now that every page has been
+// converted.
+func (g *mdGenerator) resolveBreadcrumbs(pages []*page) {
+	byPath := make(map[string]*page, len(pages))
+	for _, p := range pages {
+		byPath[filepath.ToSlash(p.relPath)] = p
+	}
+	for _, p := range pages {
+		for i := range p.data.Breadcrumbs {
+			c := &p.data.Breadcrumbs[i]
+			index, ok := byPath[c.relPath]
+			if !ok {
+				continue
+			}
+			c.URL = index.ref.URL
+			if index.ref.Title != "" {
+				c.Title = index.ref.Title
+			}
+		}
+	}
+}
+
+// titleize makes a title from a file or directory name, e.g. "Getting
+// Started" from "getting-started".
+func titleize(name string) string {
+	words := strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' || r == ' ' })
+	for i, w := range words {
+		r, size := utf8.DecodeRuneInString(w)
+		words[i] = string(unicode.ToUpper(r)) + w[size:]
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBreadcrumbs(t *testing.T) {
+	for _, tt := range []struct {
+		withExtensions string
+		want           map[string]string
+	}{
+		{"true", map[string]string{
+			"index.md":      "[Home|/index.html|true]",
+			"docs/index.md": "[Home|/index.html|false][Docs|/docs/index.html|true]",
+			"docs/guide.md": "[Home|/index.html|false][Docs|/docs/index.html|false][Guide|/docs/guide.html|true]",
+		}},
+		{"false", map[string]string{
+			"index.md":      "[Home|/index|true]",
+			"docs/index.md": "[Home|/index|false][Docs|/docs/index|true]",
+			"docs/guide.md": "[Home|/index|false][Docs|/docs/index|false][Guide|/docs/guide|true]",
+		}},
+	} {
+		t.Run("with-extensions="+tt.withExtensions, func(t *testing.T) {
+			setTestFlag(t, "with-extensions", tt.withExtensions)
+			titles := map[string]string{"index.md": "Home", "docs/index.md": "Docs", "docs/guide.md": "Guide"}
+			g := &mdGenerator{}
+			var pages []*page
+			for src, title := range titles {
+				relPath := pageOutputPath(src)
+				p := &page{src: src, relPath: relPath, ref: &pageRef{Title: title, URL: pageURL("/" + relPath)}}
+				p.data.Breadcrumbs = g.breadcrumbs(relPath, title)
+				pages = append(pages, p)
+			}
+			g.resolveBreadcrumbs(pages)
+
+			for _, p := range pages {
+				var b strings.Builder
+				for _, c := range p.data.Breadcrumbs {
+					fmt.Fprintf(&b, "[%s|%s|%t]", c.Title, c.URL, c.Current)
+				}
+				if got := b.String(); got != tt.want[p.src] {
+					t.Errorf("%s: got breadcrumbs %s, want %s", p.src, got, tt.want[p.src])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"gopkg.in/yaml.v2"
+)
+
+// cacheFlags are the flags that affect how markdown is converted to HTML;
+// changing any of them invalidates the build cache. Keep this in sync with
+// the options passed to goldmark and the sanitizer.
+var cacheFlags = []string{
+	"hard-wraps",
+	"allow-html",
+	"allow-iframe-domains",
+	"meta-table",
+	"toc-max-depth",
+	"mermaid",
+	"katex",
+	"flatten",
+	"typographer",
+	"task-lists",
+}
+
+// buildCache is a persistent cache of converted markdown, keyed by a hash of
+// the source file and its path, the conversion options and the build tool
+// itself.
+//
+// Only conversion is cached, and it depends on nothing else: the page's
+// own frontmatter is parsed from the source file, and frontmatter defaults,
+// cascades and templates are applied to the cached conversion on every
+// build. So they're deliberately not part of the key, which would only
+// throw away entries that are still valid whenever a template or the
+// config changed.
+type buildCache struct {
+	dir string
+	// key is the hash of the conversion options and the tool.
+	key []byte
+}
+
+// cachedConversion is how a conversion is stored in the cache.
+type cachedConversion struct {
+	Content string
+	TOC     string
+	Heading string
+	Anchors []string
+	Excerpt string
+	Tasks   []taskItem
+	// Frontmatter is the page's frontmatter as it appears in the source
+	// file, which is parsed again in the same way rather than converted
+	// to and from YAML, so that its values keep their types.
+	HasFrontmatter bool
+	Frontmatter    string
+	Warnings       []string
+}
+
+// newBuildCache returns a cache in dir, creating it if necessary.
+func newBuildCache(dir string) (*buildCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	// Any change to the tool, e.g. a new version of goldmark, changes
+	// its executable.
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("finding executable: %w", err)
+	}
+	f, err := os.Open(exe)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	for _, name := range cacheFlags {
+		fmt.Fprintf(h, "%s=%s\x00", name, flag.Lookup(name).Value)
+	}
+	return &buildCache{dir: dir, key: h.Sum(nil)}, nil
+}
+
+func (c *buildCache) path(name string, src []byte) string {
+	h := sha256.New()
+	h.Write(c.key)
+	fmt.Fprintf(h, "%s\x00", name)
+	h.Write(src)
+	return filepath.Join(c.dir, hex.EncodeToString(h.Sum(nil))+".json")
+}
+
+// get returns the cached conversion of the markdown in src, read from the
+// source-relative path name, if any. It's safe to call on a nil cache.
+func (c *buildCache) get(name string, src []byte) (*conversion, bool) {
+	if c == nil {
+		return nil, false
+	}
+	data, err := os.ReadFile(c.path(name, src))
+	if err != nil {
+		return nil, false
+	}
+	var cached cachedConversion
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, false
+	}
+	conv := &conversion{
+		content:  []byte(cached.Content),
+		toc:      cached.TOC,
+		heading:  cached.Heading,
+		anchors:  cached.Anchors,
+		excerpt:  cached.Excerpt,
+		tasks:    cached.Tasks,
+		warnings: cached.Warnings,
+	}
+	if cached.HasFrontmatter {
+		fm, err := parseFrontmatter([]byte(cached.Frontmatter))
+		if err != nil {
+			return nil, false
+		}
+		conv.frontmatter = fm
+	}
+	return conv, true
+}
+
+// put stores the conversion of the markdown in src. It's safe to call on a
+// nil cache.
+func (c *buildCache) put(name string, src []byte, conv *conversion) error {
+	if c == nil {
+		return nil
+	}
+	cached := cachedConversion{
+		Content:  string(conv.content),
+		TOC:      conv.toc,
+		Heading:  conv.heading,
+		Anchors:  conv.anchors,
+		Excerpt:  conv.excerpt,
+		Tasks:    conv.tasks,
+		Warnings: conv.warnings,
+	}
+	if conv.frontmatter != nil {
+		// Make sure that the frontmatter can be restored exactly;
+		// if not, the page is converted again next time.
+		raw := frontmatterSource(src)
+		if fm, err := parseFrontmatter(raw); err != nil || !reflect.DeepEqual(fm, conv.frontmatter) {
+			return nil
+		}
+		cached.HasFrontmatter = true
+		cached.Frontmatter = string(raw)
+	}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	tf, err := os.CreateTemp(c.dir, ".tmp*")
+	if err != nil {
+		return err
+	}
+	if _, err := tf.Write(data); err != nil {
+		tf.Close()
+		os.Remove(tf.Name())
+		return err
+	}
+	if err := tf.Close(); err != nil {
+		os.Remove(tf.Name())
+		return err
+	}
+	return os.Rename(tf.Name(), c.path(name, src))
+}
+
+// frontmatterSource returns the YAML of the frontmatter at the start of the
+// markdown in src, as goldmark-meta finds it: the lines after an opening
+// line of dashes, up to the next one, or to the end of the file if it's
+// never closed. It returns nil if src doesn't start with frontmatter.
+func frontmatterSource(src []byte) []byte {
+	isSeparator := func(line []byte) bool {
+		line = bytes.TrimSpace(line)
+		return len(line) > 0 && len(bytes.Trim(line, "-")) == 0
+	}
+	first, rest, _ := bytes.Cut(src, []byte("\n"))
+	if !isSeparator(first) {
+		return nil
+	}
+	var buf bytes.Buffer
+	for len(rest) > 0 {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		rest = rest[len(line):]
+		if isSeparator(line) {
+			break
+		}
+		buf.Write(line)
+	}
+	return buf.Bytes()
+}
+
+// parseFrontmatter parses frontmatter YAML as goldmark-meta does.
+func parseFrontmatter(raw []byte) (map[string]any, error) {
+	fm := map[string]any{}
+	if err := yaml.Unmarshal(raw, &fm); err != nil {
+		return nil, err
+	}
+	return fm, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yuin/goldmark"
+	meta "github.com/yuin/goldmark-meta"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// TestBuildCacheFrontmatter checks that frontmatter comes back from the
+// cache exactly as goldmark-meta parsed it, with the same types.
+func TestBuildCacheFrontmatter(t *testing.T) {
+	md := goldmark.New(goldmark.WithExtensions(meta.Meta))
+	for _, tt := range []struct {
+		name, src string
+	}{
+		{"none", "# Title\n"},
+		{"empty", "---\n---\n# Title\n"},
+		{"types", "---\ntitle: Post\nweight: 3\nratio: 1.5\ndate: 2024-01-02\ndraft: false\ntags: [a, b]\nauthor:\n  name: Jane\n---\nBody\n"},
+		{"crlf", "---\r\ntitle: Post\r\nweight: 3\r\n---\r\nBody\r\n"},
+		{"unclosed", "---\ntitle: Post\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := parser.NewContext()
+			md.Parser().Parse(text.NewReader([]byte(tt.src)), parser.WithContext(ctx))
+			fm, err := meta.TryGet(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, err := newBuildCache(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			conv := &conversion{content: []byte("<p>Body</p>"), frontmatter: fm, warnings: []string{"a warning"}}
+			if err := c.put("post.md", []byte(tt.src), conv); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := c.get("post.md", []byte(tt.src))
+			if !ok {
+				t.Fatal("page wasn't cached")
+			}
+			if !reflect.DeepEqual(got.frontmatter, fm) {
+				t.Errorf("got frontmatter %#v, want %#v", got.frontmatter, fm)
+			}
+			if !reflect.DeepEqual(got.warnings, conv.warnings) {
+				t.Errorf("got warnings %q, want %q", got.warnings, conv.warnings)
+			}
+		})
+	}
+}
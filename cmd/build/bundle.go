@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"html/template"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// bundler implements the bundle template function, which concatenates static
+// files into a single file named after a hash of its contents:
+//
+//	{{ bundle "/css/base.css" "/css/layout.css" }}
+//	-> <link rel="stylesheet" href="/bundles/0123456789ab.css">
+//
+// The files must all be stylesheets (.css) or all be scripts (.js). With
+// -bundle=false, a tag for each file is returned instead, which is easier
+// to debug.
+type bundler struct {
+	// root is the directory that the files are read from.
+	root string
+	urls urlConfig
+	// enabled is whether files are bundled.
+	enabled bool
+	// minify is whether CSS bundles are minified.
+	minify bool
+
+	mu sync.Mutex
+	// bundles maps the output path of each bundle to its contents.
+	bundles map[string][]byte
+}
+
+func (b *bundler) funcs() template.FuncMap {
+	return template.FuncMap{"bundle": b.bundle}
+}
+
+func (b *bundler) bundle(files ...string) (template.HTML, error) {
+	if len(files) == 0 {
+		return "", fmt.Errorf("bundle needs at least one file")
+	}
+	ext := path.Ext(files[0])
+	if ext != ".css" && ext != ".js" {
+		return "", fmt.Errorf("can't bundle %q; only .css and .js files can be bundled", files[0])
+	}
+	for _, f := range files[1:] {
+		if path.Ext(f) != ext {
+			return "", fmt.Errorf("can't bundle %q with %q; all files in a bundle must have the same extension", f, files[0])
+		}
+	}
+
+	if !b.enabled {
+		var tags strings.Builder
+		for i, f := range files {
+			if i > 0 {
+				tags.WriteString("\n")
+			}
+			tags.WriteString(assetTag(ext, b.urls.relURL(f)))
+		}
+		return template.HTML(tags.String()), nil
+	}
+
+	var buf bytes.Buffer
+	for _, f := range files {
+		data, err := readFileUnder(b.root, f)
+		if err != nil {
+			return "", err
+		}
+		buf.Write(data)
+		// Keep a statement that's missing its semicolon from running
+		// into the next file.
+		if ext == ".js" {
+			buf.WriteString(";")
+		}
+		buf.WriteString("\n")
+	}
+	content := buf.Bytes()
+	if b.minify && ext == ".css" {
+		content = minifyCSS(content)
+	}
+
+	sum := sha256.Sum256(content)
+	relPath := path.Join("bundles", hex.EncodeToString(sum[:6])+ext)
+	b.mu.Lock()
+	if b.bundles == nil {
+		b.bundles = make(map[string][]byte)
+	}
+	b.bundles[relPath] = content
+	b.mu.Unlock()
+	return template.HTML(assetTag(ext, b.urls.relURL(relPath))), nil
+}
+
+// assetTag returns the tag that includes the stylesheet or script at url.
+func assetTag(ext, url string) string {
+	if ext == ".css" {
+		return `<link rel="stylesheet" href="` + html.EscapeString(url) + `">`
+	}
+	return `<script src="` + html.EscapeString(url) + `"></script>`
+}
+
+// written returns the output paths of the bundles, sorted.
+func (b *bundler) written() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Sorted(maps.Keys(b.bundles))
+}
+
+// writeBundles writes every bundle that's been used to the output.
+func (b *bundler) writeBundles(out outputWriter) error {
+	for _, relPath := range b.written() {
+		if err := writeOutputBytes(out, relPath, b.bundles[relPath]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// minifyCSS removes comments and unnecessary whitespace from a stylesheet.
+// It leaves strings alone, but doesn't otherwise rewrite anything.
+func minifyCSS(css []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(css))
+	space := false
+	for i := 0; i < len(css); i++ {
+		c := css[i]
+		switch {
+		case c == '/' && i+1 < len(css) && css[i+1] == '*':
+			end := bytes.Index(css[i+2:], []byte("*/"))
+			if end < 0 {
+				return out.Bytes()
+			}
+			i += end + 3
+			space = true
+		case c == '"' || c == '\'':
+			if space {
+				writeSpace(&out)
+				space = false
+			}
+			j := i + 1
+			for ; j < len(css) && css[j] != c; j++ {
+				if css[j] == '\\' {
+					j++
+				}
+			}
+			out.Write(css[i:min(j+1, len(css))])
+			i = j
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			space = true
+		default:
+			if space && !strings.ContainsRune("{};,>", rune(c)) {
+				writeSpace(&out)
+			}
+			space = false
+			out.WriteByte(c)
+		}
+	}
+	return out.Bytes()
+}
+
+// writeSpace writes the single space that separates two tokens, unless the
+// previous character makes it unnecessary.
+func writeSpace(out *bytes.Buffer) {
+	b := out.Bytes()
+	if len(b) == 0 || strings.ContainsRune("{}:;,>", rune(b[len(b)-1])) {
+		return
+	}
+	out.WriteByte(' ')
+}
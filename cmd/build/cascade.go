@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	meta "github.com/yuin/goldmark-meta"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// sectionIndexName is the name of the markdown file that acts as the index
+// page for a directory. It's rendered as the directory's 'index.html', and
+// any 'cascade' map in its frontmatter is applied as defaults to every other
+// page in that directory and its subdirectories.
+const sectionIndexName = "_index.md"
+
+// loadCascades finds every section index under the source directory and
+// returns the 'cascade' frontmatter of each, keyed by the directory
+// containing it (relative to the source directory).
+func (g *mdGenerator) loadCascades() (map[string]map[string]any, error) {
+	cascades := make(map[string]map[string]any)
+	err := filepath.WalkDir(g.sourceDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != sectionIndexName {
+			return nil
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		ctx := parser.NewContext()
+		g.md.Parser().Parse(text.NewReader(b), parser.WithContext(ctx))
+		frontmatter, err := meta.TryGet(ctx)
+		if err != nil {
+			return frontmatterError(path, err)
+		}
+
+		raw, ok := frontmatter["cascade"]
+		if !ok {
+			return nil
+		}
+		cascade, ok := stringMap(raw)
+		if !ok {
+			return &buildError{File: path, Err: fmt.Errorf("'cascade' must be a map, not %T", raw)}
+		}
+
+		relDir, err := filepath.Rel(g.sourceDir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		cascades[relDir] = cascade
+		return nil
+	})
+	return cascades, err
+}
+
+// cascadeFor returns the merged cascaded values that apply to the markdown
+// file at relPath (relative to the source directory). Values from section
+// indexes closer to the page override those further up the tree. A section
+// index's cascade applies to its descendants, but not to itself.
+func (g *mdGenerator) cascadeFor(relPath string) map[string]any {
+	if len(g.cascades) == 0 {
+		return nil
+	}
+
+	// Build the list of directories from the page up to the root.
+	dir := filepath.Dir(relPath)
+	if filepath.Base(relPath) == sectionIndexName {
+		if dir == "." {
+			return nil
+		}
+		dir = filepath.Dir(dir)
+	}
+	var dirs []string
+	for {
+		dirs = append(dirs, dir)
+		if dir == "." {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+
+	ret := make(map[string]any)
+	for i := len(dirs) - 1; i >= 0; i-- {
+		mergeMeta(ret, g.cascades[dirs[i]])
+	}
+	return ret
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// hashTree returns the SHA-256 hash of every file under dir, keyed by its
+// path relative to dir. A missing directory is treated as empty.
+func hashTree(dir string) (map[string][sha256.Size]byte, error) {
+	hashes := make(map[string][sha256.Size]byte)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		var sum [sha256.Size]byte
+		copy(sum[:], h.Sum(nil))
+		hashes[rel] = sum
+		return nil
+	})
+	return hashes, err
+}
+
+// diffHashes returns which files were added, modified or removed between two
+// sets of hashes returned by hashTree, each sorted by name.
+func diffHashes(before, after map[string][sha256.Size]byte) (added, modified, removed []string) {
+	for name, sum := range after {
+		prev, ok := before[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case prev != sum:
+			modified = append(modified, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(modified)
+	sort.Strings(removed)
+	return added, modified, removed
+}
+
+// logChanges logs which files were added, modified or removed between two
+// sets of hashes returned by hashTree.
+func logChanges(before, after map[string][sha256.Size]byte) {
+	added, modified, removed := diffHashes(before, after)
+	if len(added)+len(modified)+len(removed) == 0 {
+		log.Printf("changes: no output files changed")
+		return
+	}
+	for _, c := range []struct {
+		label string
+		names []string
+	}{
+		{"added", added},
+		{"modified", modified},
+		{"removed", removed},
+	} {
+		for _, name := range c.names {
+			log.Printf("changes: %-8s %s", c.label, name)
+		}
+	}
+	log.Printf("changes: %d added, %d modified, %d removed", len(added), len(modified), len(removed))
+}
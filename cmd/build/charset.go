@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// charsetEncoding describes a character set that rendered pages can be
+// transcoded to.
+type charsetEncoding struct {
+	// name is the canonical name, as written in the <meta charset> tag.
+	name string
+	// encode returns the single-byte encoding of r, if one exists. It is
+	// nil for UTF-8, which requires no transcoding.
+	encode func(r rune) (byte, bool)
+}
+
+// cp1252High maps the bytes 0x80-0x9F in windows-1252 to their Unicode code
+// points; zero entries are unassigned.
+var cp1252High = [32]rune{
+	0x20AC, 0, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017D, 0,
+	0, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0, 0x017E, 0x0178,
+}
+
+var (
+	charsetUTF8   = &charsetEncoding{name: "UTF-8"}
+	charsetASCII  = &charsetEncoding{name: "US-ASCII", encode: encodeBelow(0x80)}
+	charsetLatin1 = &charsetEncoding{name: "ISO-8859-1", encode: encodeBelow(0x100)}
+	charsetCP1252 = &charsetEncoding{name: "windows-1252", encode: func(r rune) (byte, bool) {
+		if r < 0x80 || (r >= 0xA0 && r < 0x100) {
+			return byte(r), true
+		}
+		for i, cr := range cp1252High {
+			if cr != 0 && cr == r {
+				return byte(0x80 + i), true
+			}
+		}
+		return 0, false
+	}}
+)
+
+// charsets maps lower-cased charset names and aliases to their encoding.
+var charsets = map[string]*charsetEncoding{
+	"utf-8":        charsetUTF8,
+	"utf8":         charsetUTF8,
+	"us-ascii":     charsetASCII,
+	"ascii":        charsetASCII,
+	"iso-8859-1":   charsetLatin1,
+	"latin1":       charsetLatin1,
+	"windows-1252": charsetCP1252,
+	"cp1252":       charsetCP1252,
+}
+
+func encodeBelow(limit rune) func(rune) (byte, bool) {
+	return func(r rune) (byte, bool) {
+		if r < limit {
+			return byte(r), true
+		}
+		return 0, false
+	}
+}
+
+// lookupCharset returns the encoding for the given charset name, or an error
+// listing the supported charsets.
+func lookupCharset(name string) (*charsetEncoding, error) {
+	if enc, ok := charsets[strings.ToLower(name)]; ok {
+		return enc, nil
+	}
+	var names []string
+	for n := range charsets {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("unsupported charset %q; supported charsets are: %s", name, strings.Join(names, ", "))
+}
+
+// metaCharsetRe matches the <meta charset> declaration in a rendered page.
+var metaCharsetRe = regexp.MustCompile(`(?i)<meta\s+charset\s*=\s*["']?[^"'\s/>]*["']?`)
+
+// transcode converts the UTF-8 encoded page in b to this encoding, and
+// rewrites any <meta charset> declaration to match. Characters that can't be
+// represented are written as numeric character references.
+func (c *charsetEncoding) transcode(b []byte) []byte {
+	if c.encode == nil {
+		return b
+	}
+	b = metaCharsetRe.ReplaceAllLiteral(b, []byte(`<meta charset="`+c.name+`"`))
+
+	var out bytes.Buffer
+	out.Grow(len(b))
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		b = b[size:]
+		if enc, ok := c.encode(r); ok {
+			out.WriteByte(enc)
+		} else {
+			fmt.Fprintf(&out, "&#%d;", r)
+		}
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// checkCleanDir returns an error if outDir is somewhere that cleaning would
+// be destructive: the root of the filesystem, the home directory, or a
+// directory containing the working directory or one of the given source
+// directories (such as the markdown or static files). Empty source
+// directories are ignored.
+func checkCleanDir(outDir string, sourceDirs ...string) error {
+	out, err := realPath(outDir)
+	if err != nil {
+		return err
+	}
+	if filepath.Dir(out) == out {
+		return fmt.Errorf("refusing to clean %s: it's the root of the filesystem", outDir)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		if home, err := realPath(home); err == nil && home == out {
+			return fmt.Errorf("refusing to clean %s: it's the home directory", outDir)
+		}
+	}
+	if wd, err := os.Getwd(); err == nil {
+		if wd, err := realPath(wd); err == nil && within(wd, out) {
+			return fmt.Errorf("refusing to clean %s: it contains the working directory", outDir)
+		}
+	}
+	for _, dir := range sourceDirs {
+		if dir == "" {
+			continue
+		}
+		src, err := realPath(dir)
+		if err != nil {
+			return err
+		}
+		if within(src, out) {
+			return fmt.Errorf("refusing to clean %s: it contains %s", outDir, dir)
+		}
+	}
+	return nil
+}
+
+// realPath returns the absolute path of p with any symlinks resolved, or
+// just the absolute path if p doesn't exist.
+func realPath(p string) (string, error) {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return "", err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if errors.Is(err, fs.ErrNotExist) {
+		return abs, nil
+	}
+	return real, err
+}
+
+// within reports whether the path p is dir or is inside it. Both must be
+// absolute and clean.
+func within(p, dir string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && filepath.IsLocal(rel)
+}
+
+// cleanOnly cleans outDir (or, with -output-prefix, the prefix directory
+// inside it) as the build would, for -clean-only.
+func cleanOnly(sourceDir, outDir string) error {
+	prefix, err := parseOutputPrefix(*outputPrefix)
+	if err != nil {
+		return err
+	}
+	if prefix != "" {
+		outDir = filepath.Join(outDir, filepath.FromSlash(prefix))
+		if _, err := os.Stat(outDir); errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+	}
+	if err := checkCleanDir(outDir, sourceDir, *staticDir); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	keep, err := newPreserveSet(cfg.Preserve)
+	if err != nil {
+		return err
+	}
+	return cleanDirectory(outDir, keep)
+}
+
+// preservePattern is one of the config file's 'preserve' patterns, which
+// match files and directories in the output directory that cleaning leaves
+// alone.
+type preservePattern struct {
+	// glob matches the name of a file or directory at any depth or, if
+	// anchored, its whole slash-separated path in the output.
+	glob     string
+	anchored bool
+	// dirOnly is whether the pattern only matches directories.
+	dirOnly bool
+	// re, if non-nil, is used instead of glob, and matches the whole
+	// path.
+	re *regexp.Regexp
+}
+
+// preserveSet is the set of 'preserve' patterns. A preserved directory is
+// kept along with everything in it.
+type preserveSet []preservePattern
+
+// newPreserveSet parses 'preserve' patterns. A pattern is either:
+//
+//   - "re:" followed by a regular expression, which must match the whole
+//     slash-separated path of a file or directory, e.g. `re:.*\.txt`;
+//   - a glob, e.g. "*.txt", which matches names at any depth unless it
+//     contains a slash ("/robots.txt", "docs/*.pdf"), in which case it
+//     matches the whole path. A trailing slash (".well-known/") only
+//     matches directories.
+func newPreserveSet(patterns []string) (preserveSet, error) {
+	var set preserveSet
+	for _, s := range patterns {
+		if expr, ok := strings.CutPrefix(s, "re:"); ok {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid preserve pattern %q: %w", s, err)
+			}
+			set = append(set, preservePattern{re: re})
+			continue
+		}
+		glob, dirOnly := strings.CutSuffix(s, "/")
+		p := preservePattern{dirOnly: dirOnly, anchored: strings.Contains(glob, "/")}
+		p.glob = strings.TrimPrefix(glob, "/")
+		if _, err := path.Match(p.glob, ""); err != nil || p.glob == "" {
+			return nil, fmt.Errorf("invalid preserve pattern %q", s)
+		}
+		set = append(set, p)
+	}
+	return set, nil
+}
+
+// preserves reports whether the file or directory at the slash-separated
+// path rel, relative to the output directory, is preserved.
+func (s preserveSet) preserves(rel string, isDir bool) bool {
+	for _, p := range s {
+		switch {
+		case p.re != nil:
+			if p.re.MatchString(rel) {
+				return true
+			}
+		case p.dirOnly && !isDir:
+		default:
+			name := rel
+			if !p.anchored {
+				name = path.Base(rel)
+			}
+			if ok, _ := path.Match(p.glob, name); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// defaultCollectionPageSize is the number of items in each page of a JSON
+// collection that doesn't set 'page_size'.
+const defaultCollectionPageSize = 10
+
+// defaultCollectionFields are the fields of each item in a JSON collection
+// that doesn't set 'fields'.
+var defaultCollectionFields = []string{"title", "url", "date"}
+
+// jsonCollection configures a set of pages that's written as paginated JSON,
+// e.g. for a frontend that loads posts as they're scrolled to. It's an entry
+// in the 'collections' map of the config file.
+type jsonCollection struct {
+	// Match is a glob, as in 'defaults', matching the source paths of the
+	// pages in the collection.
+	Match string `yaml:"match"`
+	// Path is the output directory of the collection's pages, which are
+	// named "page/1.json" and so on; it defaults to "api/" followed by
+	// the collection's name.
+	Path string `yaml:"path"`
+	// PageSize is the number of items in each page.
+	PageSize int `yaml:"page_size"`
+	// Fields are the fields of each item: any of "title", "url",
+	// "permalink", "date", "weight", "word_count" and "reading_time", or
+	// the names of frontmatter keys.
+	Fields []string `yaml:"fields"`
+	// SortBy and Order sort the items as the sortBy template function
+	// does; by default, they're in the same order as .Site.Pages.
+	SortBy string `yaml:"sort_by"`
+	Order  string `yaml:"order"`
+}
+
+// validate checks the collection's configuration, and fills in defaults.
+func (c *jsonCollection) validate(name string) error {
+	if c.Match == "" {
+		return fmt.Errorf("collection %q has no 'match' glob", name)
+	}
+	if _, err := path.Match(c.Match, ""); err != nil {
+		return fmt.Errorf("collection %q has invalid glob %q: %w", name, c.Match, err)
+	}
+	if c.Path == "" {
+		c.Path = path.Join("api", name)
+	}
+	if !filepath.IsLocal(filepath.FromSlash(c.Path)) {
+		return fmt.Errorf("collection %q has invalid output path %q", name, c.Path)
+	}
+	switch {
+	case c.PageSize == 0:
+		c.PageSize = defaultCollectionPageSize
+	case c.PageSize < 0:
+		return fmt.Errorf("collection %q has invalid page_size %d", name, c.PageSize)
+	}
+	if len(c.Fields) == 0 {
+		c.Fields = defaultCollectionFields
+	}
+	if c.Order != "" && c.SortBy == "" {
+		return fmt.Errorf("collection %q has an 'order' but no 'sort_by'", name)
+	}
+	if c.Order != "" && c.Order != "asc" && c.Order != "desc" {
+		return fmt.Errorf("collection %q has invalid order %q; must be 'asc' or 'desc'", name, c.Order)
+	}
+	return nil
+}
+
+// collectionPage is one page of a JSON collection.
+type collectionPage struct {
+	Page       int              `json:"page"`
+	TotalPages int              `json:"total_pages"`
+	TotalItems int              `json:"total_items"`
+	Items      []map[string]any `json:"items"`
+	// The links are to other pages of the collection, as site-absolute
+	// URLs; Prev and Next are omitted on the first and last pages.
+	First string `json:"first"`
+	Last  string `json:"last"`
+	Prev  string `json:"prev,omitempty"`
+	Next  string `json:"next,omitempty"`
+}
+
+// collectionFiles returns the contents of each page of the collection, keyed
+// by its path relative to the output root. The collection's items are taken
+// from pages, whose source paths are relative to sourceDir.
+func collectionFiles(c *jsonCollection, pages []*page, sourceDir string, urls urlConfig) (map[string][]byte, error) {
+	var refs []*pageRef
+	for _, p := range pages {
+		relSrc, err := filepath.Rel(sourceDir, p.src)
+		if err != nil {
+			return nil, err
+		}
+		if ok, _ := path.Match(c.Match, filepath.ToSlash(relSrc)); ok {
+			refs = append(refs, p.ref)
+		}
+	}
+	sortPages(refs)
+	if c.SortBy != "" {
+		var order []string
+		if c.Order != "" {
+			order = append(order, c.Order)
+		}
+		var err error
+		if refs, err = sortBy(refs, c.SortBy, order...); err != nil {
+			return nil, err
+		}
+	}
+
+	// There's always a first page, even if it's empty.
+	total := max(1, (len(refs)+c.PageSize-1)/c.PageSize)
+	pagePath := func(n int) string {
+		return path.Join(c.Path, "page", fmt.Sprintf("%d.json", n))
+	}
+	files := make(map[string][]byte, total)
+	for n := 1; n <= total; n++ {
+		cp := collectionPage{
+			Page:       n,
+			TotalPages: total,
+			TotalItems: len(refs),
+			Items:      []map[string]any{},
+			First:      urls.relURL(pagePath(1)),
+			Last:       urls.relURL(pagePath(total)),
+		}
+		if n > 1 {
+			cp.Prev = urls.relURL(pagePath(n - 1))
+		}
+		if n < total {
+			cp.Next = urls.relURL(pagePath(n + 1))
+		}
+		start := (n - 1) * c.PageSize
+		for _, ref := range refs[start:min(start+c.PageSize, len(refs))] {
+			cp.Items = append(cp.Items, collectionItem(ref, c.Fields))
+		}
+		data, err := json.MarshalIndent(cp, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		files[pagePath(n)] = append(data, '\n')
+	}
+	return files, nil
+}
+
+// collectionItem returns the given fields of the page; fields that the page
+// doesn't have are omitted.
+func collectionItem(ref *pageRef, fields []string) map[string]any {
+	item := make(map[string]any, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "title":
+			item[field] = ref.Title
+		case "url":
+			item[field] = ref.URL
+		case "permalink":
+			if ref.Permalink != "" {
+				item[field] = ref.Permalink
+			}
+		case "date":
+			if !ref.Date.IsZero() {
+				item[field] = ref.Date.Format(time.RFC3339)
+			}
+		case "weight":
+			item[field] = ref.Weight
+		case "word_count":
+			item[field] = ref.WordCount
+		case "reading_time":
+			item[field] = ref.ReadingTime
+		default:
+			if v, ok := ref.Params[field]; ok {
+				item[field] = jsonValue(v)
+			}
+		}
+	}
+	return item
+}
+
+// writeCollections writes every page of every JSON collection.
+func writeCollections(out outputWriter, collections map[string]*jsonCollection, pages []*page, sourceDir string, urls urlConfig, outputs map[string]string) error {
+	names := make([]string, 0, len(collections))
+	for name := range collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		files, err := collectionFiles(collections[name], pages, sourceDir, urls)
+		if err != nil {
+			return fmt.Errorf("collection %q: %w", name, err)
+		}
+		paths := make([]string, 0, len(files))
+		for p := range files {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+		for _, p := range paths {
+			rel := filepath.FromSlash(p)
+			if other, ok := outputs[rel]; ok {
+				return fmt.Errorf("%s from collection %q is also generated from %s", p, name, other)
+			}
+			if err := writeOutputBytes(out, rel, files[p]); err != nil {
+				return fmt.Errorf("error writing %s: %w", p, err)
+			}
+			outputs[rel] = "collection " + name
+		}
+	}
+	return nil
+}
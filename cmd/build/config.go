@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"iter"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// siteConfig is the (optional) site-wide configuration, loaded from the YAML
+// file given by the -config flag.
+type siteConfig struct {
+	// Title and Description describe the site as a whole.
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+
+	// ThemeColor is the color that browsers may use for their UI around
+	// the site, e.g. "#336699". If ThemeColorDark is also set, ThemeColor
+	// only applies in light mode.
+	ThemeColor     string `yaml:"theme_color"`
+	ThemeColorDark string `yaml:"theme_color_dark"`
+	// ColorScheme lists the color schemes that the site supports, as in
+	// the color-scheme meta tag, e.g. "light dark".
+	ColorScheme string `yaml:"color_scheme"`
+
+	// Defaults maps a path glob to frontmatter values that are applied to
+	// every page whose source path matches the glob. Globs are matched
+	// with path.Match against the slash-separated path of the markdown
+	// file, relative to the source directory (e.g. "blog/*.md").
+	//
+	// When multiple globs match a page, values from more specific globs
+	// (those with more literal, non-wildcard characters) override values
+	// from less specific ones. A page's own frontmatter always wins.
+	Defaults map[string]map[string]any `yaml:"defaults"`
+
+	// PlainDefaults are frontmatter values (e.g. a 'layout') applied to
+	// pages that have no frontmatter of their own, over any other
+	// defaults; see -no-frontmatter.
+	PlainDefaults map[string]any `yaml:"plain_defaults"`
+
+	// DateFormats lists additional Go time layouts (e.g. "2006/01/02" or
+	// "02 Jan 2006") that are accepted for the 'date' frontmatter key.
+	// They're tried in order, before the built-in defaultDateFormats.
+	DateFormats []string `yaml:"date_formats"`
+
+	// Fragments maps the names of partials (e.g. "_contact-form") to
+	// paths in the output (e.g. "fragments/contact.html") that they're
+	// also rendered to on their own, without a layout, so that they can
+	// be fetched directly.
+	Fragments map[string]string `yaml:"fragments"`
+
+	// Collections maps a name to a set of pages that's written as
+	// paginated JSON; see jsonCollection.
+	Collections map[string]*jsonCollection `yaml:"collections"`
+
+	// Variants maps a name to an extra rendering of pages with another
+	// layout, e.g. a printable version; see pageVariant.
+	Variants map[string]*pageVariant `yaml:"variants"`
+
+	// LayoutAliases maps other names for layouts, which pages can use in
+	// their 'layout' frontmatter, to the layouts that they stand for.
+	LayoutAliases map[string]string `yaml:"layout_aliases"`
+
+	// Preserve lists patterns of files and directories in the output
+	// directory that cleaning leaves alone; see newPreserveSet.
+	Preserve []string `yaml:"preserve"`
+}
+
+// defaultDateFormats are the layouts always accepted for the 'date'
+// frontmatter key.
+var defaultDateFormats = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseDate parses a frontmatter date value, trying each configured format
+// in turn.
+func (c *siteConfig) parseDate(v any) (time.Time, error) {
+	switch v := v.(type) {
+	case time.Time:
+		return v, nil
+	case string:
+		formats := append(append([]string(nil), c.DateFormats...), defaultDateFormats...)
+		for _, layout := range formats {
+			if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, fmt.Errorf("date %q does not match any of the formats: %q", v, formats)
+	default:
+		return time.Time{}, fmt.Errorf("date must be a string, not %T", v)
+	}
+}
+
+// loadConfig reads the configuration file at the given path. An empty path
+// returns an empty configuration.
+func loadConfig(fpath string) (*siteConfig, error) {
+	cfg := &siteConfig{}
+	if fpath == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", fpath, err)
+	}
+
+	// Validate the globs up-front so that a typo doesn't silently match
+	// nothing.
+	for glob := range cfg.Defaults {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid defaults glob %q: %w", glob, err)
+		}
+	}
+	for _, v := range strings.Fields(cfg.ColorScheme) {
+		if !slices.Contains([]string{"normal", "light", "dark", "only"}, v) {
+			return nil, fmt.Errorf("invalid color_scheme %q; must be a list of 'normal', 'light', 'dark' or 'only'", cfg.ColorScheme)
+		}
+	}
+	for name, dst := range cfg.Fragments {
+		if !filepath.IsLocal(filepath.FromSlash(dst)) {
+			return nil, fmt.Errorf("fragment %q has invalid output path %q", name, dst)
+		}
+	}
+	for name, v := range cfg.Variants {
+		if v == nil {
+			return nil, fmt.Errorf("variant %q has no configuration", name)
+		}
+		if err := v.validate(name); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := newPreserveSet(cfg.Preserve); err != nil {
+		return nil, err
+	}
+	for name, c := range cfg.Collections {
+		if c == nil {
+			return nil, fmt.Errorf("collection %q has no configuration", name)
+		}
+		if err := c.validate(name); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// site returns the site-wide data for templates from the configuration,
+// without any pages.
+func (c *siteConfig) site() *siteData {
+	return &siteData{
+		Title:          c.Title,
+		Description:    c.Description,
+		ThemeColor:     c.ThemeColor,
+		ThemeColorDark: c.ThemeColorDark,
+		ColorScheme:    c.ColorScheme,
+	}
+}
+
+// defaultsFor returns the merged default frontmatter values for the markdown
+// file at relPath (relative to the source directory).
+func (c *siteConfig) defaultsFor(relPath string) map[string]any {
+	relPath = filepath.ToSlash(relPath)
+
+	matched := matchGlobs(maps.Keys(c.Defaults), relPath)
+	if len(matched) == 0 {
+		return nil
+	}
+
+	// Apply the least specific globs first, so that more specific ones
+	// override them.
+	ret := make(map[string]any)
+	for _, glob := range matched {
+		mergeMeta(ret, c.Defaults[glob])
+	}
+	return ret
+}
+
+// matchGlobs returns the globs that match the slash-separated path relPath,
+// from least to most specific (see globSpecificity). Ties are broken by the
+// glob itself so that the result is deterministic.
+func matchGlobs(globs iter.Seq[string], relPath string) []string {
+	var matched []string
+	for glob := range globs {
+		if ok, _ := path.Match(glob, relPath); ok {
+			matched = append(matched, glob)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		si, sj := globSpecificity(matched[i]), globSpecificity(matched[j])
+		if si != sj {
+			return si < sj
+		}
+		return matched[i] < matched[j]
+	})
+	return matched
+}
+
+// globSpecificity returns the number of literal (non-wildcard) characters in
+// a glob pattern.
+func globSpecificity(glob string) int {
+	n := 0
+	inClass := false
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case inClass:
+			if c == ']' {
+				inClass = false
+			}
+		case c == '[':
+			inClass = true
+		case c == '*' || c == '?':
+		case c == '\\':
+			i++
+			n++
+		default:
+			n++
+		}
+	}
+	return n
+}
+
+// mergeMeta copies all values from src into dst, overwriting any existing
+// keys.
+func mergeMeta(dst, src map[string]any) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// stringMap converts a map decoded from YAML into a map with string keys.
+func stringMap(v any) (map[string]any, bool) {
+	switch m := v.(type) {
+	case map[string]any:
+		return m, true
+	case map[any]any:
+		ret := make(map[string]any, len(m))
+		for k, v := range m {
+			ks, ok := k.(string)
+			if !ok {
+				return nil, false
+			}
+			ret[ks] = v
+		}
+		return ret, true
+	default:
+		return nil, false
+	}
+}
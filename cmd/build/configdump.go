@@ -0,0 +1,67 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// redactedFlags are the flags whose values are replaced with
+// redactedValue by -config-dump, e.g. because they hold credentials.
+var redactedFlags = map[string]bool{}
+
+const redactedValue = "(redacted)"
+
+// effectiveConfig is the configuration printed by -config-dump.
+type effectiveConfig struct {
+	// Flags maps the name of every flag to its value, whether it was set
+	// on the command line or is the default.
+	Flags map[string]any `yaml:"flags"`
+	// FlagsSet lists the flags that were set on the command line.
+	FlagsSet []string `yaml:"flags_set"`
+	// Config is the -config file, with its defaults filled in.
+	Config *siteConfig `yaml:"config"`
+}
+
+// dumpConfig writes the effective configuration, from the flags and the
+// -config file, to w as YAML.
+func dumpConfig(w io.Writer) error {
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+
+	ec := effectiveConfig{
+		Flags:    make(map[string]any),
+		FlagsSet: []string{},
+		Config:   cfg,
+	}
+	flag.VisitAll(func(f *flag.Flag) {
+		var v any = f.Value.String()
+		if g, ok := f.Value.(flag.Getter); ok {
+			v = g.Get()
+		}
+		if d, ok := v.(time.Duration); ok {
+			v = d.String()
+		}
+		if redactedFlags[f.Name] && f.Value.String() != "" {
+			v = redactedValue
+		}
+		ec.Flags[f.Name] = v
+	})
+	flag.Visit(func(f *flag.Flag) {
+		ec.FlagsSet = append(ec.FlagsSet, f.Name)
+	})
+	sort.Strings(ec.FlagsSet)
+
+	data, err := yaml.Marshal(ec)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+const (
+	// cspStylesheet is the stylesheet that inline styles are moved to with
+	// -csp.
+	cspStylesheet = "csp-styles.css"
+	// cspFile is where -csp writes the Content-Security-Policy header.
+	cspFile = "csp.txt"
+)
+
+// cspCollector rewrites pages so that they work under a strict
+// Content-Security-Policy: inline <style> elements and style attributes are
+// moved to an external stylesheet, and the hashes of inline scripts are
+// recorded so that they can be allowed by the policy.
+type cspCollector struct {
+	mu sync.Mutex
+	// href is the URL of the stylesheet that styles are moved to.
+	href string
+	// dataImages is whether images may be data URIs, as they are with
+	// -inline-images-below.
+	dataImages bool
+	// scriptHashes are the CSP source expressions for every inline script.
+	scriptHashes map[string]bool
+	// styles maps the hash of each block of CSS to the CSS.
+	styles map[string]string
+}
+
+func newCSPCollector(urls urlConfig) *cspCollector {
+	return &cspCollector{
+		href:         urls.relURL("/" + cspStylesheet),
+		scriptHashes: make(map[string]bool),
+		styles:       make(map[string]string),
+	}
+}
+
+// rewrite returns page with its inline styles replaced by a link to the
+// external stylesheet, recording the styles and any inline script hashes.
+func (c *cspCollector) rewrite(page []byte) []byte {
+	var (
+		out       bytes.Buffer
+		needLink  bool
+		linked    bool
+		inStyle   bool
+		inScript  bool
+		styleText strings.Builder
+		script    bytes.Buffer
+	)
+	z := html.NewTokenizer(bytes.NewReader(page))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		raw := z.Raw()
+
+		switch {
+		case inStyle:
+			if tt == html.EndTagToken && tagAtom(z) == atom.Style {
+				c.addStyle(styleText.String())
+				styleText.Reset()
+				inStyle = false
+			} else {
+				styleText.Write(raw)
+			}
+			continue
+		case inScript:
+			if tt == html.EndTagToken && tagAtom(z) == atom.Script {
+				c.addScript(script.Bytes())
+				script.Reset()
+				inScript = false
+				out.Write(raw)
+			} else {
+				script.Write(raw)
+				out.Write(raw)
+			}
+			continue
+		}
+
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			if tt == html.EndTagToken && tagAtom(z) == atom.Head && needLink && !linked {
+				fmt.Fprintf(&out, `<link rel="stylesheet" href="%s">`, html.EscapeString(c.href))
+				linked = true
+			}
+			out.Write(raw)
+			continue
+		}
+
+		// Copy the raw bytes, since reading the token reuses them.
+		raw = slices.Clone(raw)
+		tok := z.Token()
+		switch {
+		case tok.DataAtom == atom.Style && tt == html.StartTagToken:
+			inStyle = true
+			needLink = true
+			continue
+		case tok.DataAtom == atom.Script && tt == html.StartTagToken && !hasAttr(tok, "src"):
+			inScript = true
+		}
+
+		if i := slices.IndexFunc(tok.Attr, func(a html.Attribute) bool { return a.Key == "style" }); i >= 0 {
+			class := c.addStyleAttr(tok.Attr[i].Val)
+			tok.Attr = slices.Delete(tok.Attr, i, i+1)
+			if j := slices.IndexFunc(tok.Attr, func(a html.Attribute) bool { return a.Key == "class" }); j >= 0 {
+				tok.Attr[j].Val += " " + class
+			} else {
+				tok.Attr = append(tok.Attr, html.Attribute{Key: "class", Val: class})
+			}
+			needLink = true
+			out.WriteString(tok.String())
+			continue
+		}
+		out.Write(raw)
+	}
+	return out.Bytes()
+}
+
+func tagAtom(z *html.Tokenizer) atom.Atom {
+	name, _ := z.TagName()
+	return atom.Lookup(name)
+}
+
+func hasAttr(tok html.Token, key string) bool {
+	return slices.ContainsFunc(tok.Attr, func(a html.Attribute) bool { return a.Key == key })
+}
+
+func (c *cspCollector) addScript(script []byte) {
+	sum := sha256.Sum256(script)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.scriptHashes["'sha256-"+base64.StdEncoding.EncodeToString(sum[:])+"'"] = true
+}
+
+func (c *cspCollector) addStyle(css string) {
+	css = strings.TrimSpace(css)
+	if css == "" {
+		return
+	}
+	sum := sha256.Sum256([]byte(css))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.styles[hex.EncodeToString(sum[:])] = css
+}
+
+// addStyleAttr records the declarations from a style attribute, and returns
+// the class that applies them.
+func (c *cspCollector) addStyleAttr(decls string) string {
+	sum := sha256.Sum256([]byte(decls))
+	class := "csp-" + hex.EncodeToString(sum[:6])
+	c.addStyle(fmt.Sprintf(".%s { %s }", class, strings.TrimSpace(decls)))
+	return class
+}
+
+// stylesheet returns the contents of the external stylesheet.
+func (c *cspCollector) stylesheet() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var buf bytes.Buffer
+	for _, hash := range slices.Sorted(maps.Keys(c.styles)) {
+		buf.WriteString(c.styles[hash])
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// policy returns a Content-Security-Policy header that allows the site's own
+// resources and its inline scripts, and nothing else.
+func (c *cspCollector) policy() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	scriptSrc := append([]string{"'self'"}, slices.Sorted(maps.Keys(c.scriptHashes))...)
+	imgSrc := ""
+	if c.dataImages {
+		imgSrc = " img-src 'self' data:;"
+	}
+	return []byte(fmt.Sprintf("Content-Security-Policy: default-src 'self'; script-src %s; style-src 'self';%s object-src 'none'; base-uri 'self'\n",
+		strings.Join(scriptSrc, " "), imgSrc))
+}
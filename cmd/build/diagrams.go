@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// diagramRenderer renders Mermaid diagrams ("mermaid" code blocks) and KaTeX
+// math ("math" code blocks) at build time, using the Mermaid CLI (mmdc) and
+// the KaTeX CLI.
+//
+// The rendered SVG and HTML would be mangled by the HTML sanitizer, so the
+// code block is first replaced with a placeholder, which expand replaces
+// with the rendered output once the page has been sanitized. If a tool
+// isn't configured, can't be found, or fails, the code block is instead
+// rendered as markup for Mermaid or KaTeX to render in the browser.
+type diagramRenderer struct {
+	// commands maps each kind of block that's handled to the command
+	// that renders it. An empty command means that kind is rendered
+	// client-side, e.g. because the command couldn't be found.
+	commands map[string]string
+
+	// cacheDir, if non-empty, is where rendered output is cached across
+	// builds, keyed by a hash of the source.
+	cacheDir string
+
+	mu       sync.Mutex
+	rendered map[string]string // hash -> output
+	warned   map[string]bool   // commands that we've warned about
+}
+
+// diagramPlaceholderRe matches the placeholders written by render, after
+// sanitization.
+var diagramPlaceholderRe = regexp.MustCompile(`<div id="rp-diagram-([0-9a-f]+)"></div>`)
+
+// newDiagramRenderer returns a diagramRenderer that renders the kinds of
+// blocks in commands with the corresponding command, or nil if commands is
+// empty. Commands that can't be found are logged, and their blocks are
+// rendered client-side.
+func newDiagramRenderer(commands map[string]string, cacheDir string) *diagramRenderer {
+	if len(commands) == 0 {
+		return nil
+	}
+	d := &diagramRenderer{commands: make(map[string]string), cacheDir: cacheDir}
+	for kind, command := range commands {
+		if _, err := exec.LookPath(command); err != nil {
+			d.warnOnce(kind, err)
+			command = ""
+		}
+		d.commands[kind] = command
+	}
+	return d
+}
+
+// handles reports whether the renderer handles code blocks of the given
+// language.
+func (d *diagramRenderer) handles(kind string) bool {
+	if d == nil {
+		return false
+	}
+	_, ok := d.commands[kind]
+	return ok
+}
+
+// render returns the HTML for a diagram or math code block of the given
+// kind, and whether it was rendered at build time; if it wasn't, because
+// its command is missing or failed, it's rendered client-side.
+func (d *diagramRenderer) render(kind, src string) (string, bool) {
+	sum := sha256.Sum256([]byte(kind + "\x00" + src))
+	hash := hex.EncodeToString(sum[:16])
+	if _, err := d.lookup(kind, hash, src); err != nil {
+		d.warnOnce(kind, err)
+		return clientSideDiagram(kind, src), false
+	}
+	return fmt.Sprintf(`<div id="rp-diagram-%s"></div>`+"\n", hash), true
+}
+
+// lookup returns the rendered output for src, from the cache if possible.
+func (d *diagramRenderer) lookup(kind, hash, src string) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if out, ok := d.rendered[hash]; ok {
+		return out, nil
+	}
+
+	var cachePath string
+	if d.cacheDir != "" {
+		cachePath = filepath.Join(d.cacheDir, hash+".html")
+		if data, err := os.ReadFile(cachePath); err == nil {
+			d.store(hash, string(data))
+			return string(data), nil
+		}
+	}
+
+	command := d.commands[kind]
+	if command == "" {
+		return "", fmt.Errorf("no command to render %s blocks", kind)
+	}
+	var out string
+	var err error
+	switch kind {
+	case "mermaid":
+		out, err = runMermaid(command, src)
+	case "math":
+		out, err = runKaTeX(command, src)
+	}
+	if err != nil {
+		return "", err
+	}
+	d.store(hash, out)
+	if cachePath != "" {
+		if err := os.MkdirAll(d.cacheDir, 0755); err == nil {
+			err = os.WriteFile(cachePath, []byte(out), 0644)
+		}
+		if err != nil {
+			log.Printf("warning: error caching rendered %s: %v", kind, err)
+		}
+	}
+	return out, nil
+}
+
+func (d *diagramRenderer) store(hash, out string) {
+	if d.rendered == nil {
+		d.rendered = make(map[string]string)
+	}
+	d.rendered[hash] = out
+}
+
+// warnOnce logs that a kind of block is being rendered client-side, once per
+// kind of block.
+func (d *diagramRenderer) warnOnce(kind string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.warned[kind] {
+		return
+	}
+	if d.warned == nil {
+		d.warned = make(map[string]bool)
+	}
+	d.warned[kind] = true
+	log.Printf("warning: rendering %s blocks client-side: %v", kind, err)
+}
+
+// clientSideDiagramsAttr is set on a document with a diagram or math block
+// that was rendered client-side because its command is missing or failed,
+// so that the page isn't cached and the block is retried next time.
+const clientSideDiagramsAttr = "rp-client-side-diagrams"
+
+// expand replaces the placeholders in sanitized HTML with the rendered
+// output that they stand for.
+func (d *diagramRenderer) expand(b []byte) []byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return diagramPlaceholderRe.ReplaceAllFunc(b, func(m []byte) []byte {
+		out, ok := d.rendered[string(diagramPlaceholderRe.FindSubmatch(m)[1])]
+		if !ok {
+			return nil
+		}
+		return []byte(out)
+	})
+}
+
+// clientSideDiagram returns the markup for a diagram or math block that's
+// rendered in the browser, by mermaid.js or KaTeX's auto-render extension.
+func clientSideDiagram(kind, src string) string {
+	if kind == "math" {
+		return `<div class="math">\[` + html.EscapeString(src) + `\]</div>` + "\n"
+	}
+	return `<pre class="mermaid">` + html.EscapeString(src) + "</pre>\n"
+}
+
+// runMermaid renders a Mermaid diagram to SVG with the Mermaid CLI.
+func runMermaid(command, src string) (string, error) {
+	dir, err := os.MkdirTemp("", "rp-mermaid")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	in, out := filepath.Join(dir, "in.mmd"), filepath.Join(dir, "out.svg")
+	if err := os.WriteFile(in, []byte(src), 0644); err != nil {
+		return "", err
+	}
+	cmd := exec.Command(command, "--quiet", "-i", in, "-o", out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("running %s: %w: %s", command, err, bytes.TrimSpace(output))
+	}
+	svg, err := os.ReadFile(out)
+	if err != nil {
+		return "", err
+	}
+	return `<div class="mermaid-diagram">` + string(svg) + "</div>\n", nil
+}
+
+// runKaTeX renders a block of TeX math to HTML with the KaTeX CLI.
+func runKaTeX(command, src string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(command, "--display-mode")
+	cmd.Stdin = strings.NewReader(src)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("running %s: %w: %s", command, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return `<div class="math">` + strings.TrimSpace(stdout.String()) + "</div>\n", nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// directivesKey is the reserved frontmatter key for build directives, which
+// override global settings for a single page, e.g.
+//
+//	rp:
+//	  sanitize: false
+//	  extension: htm
+const directivesKey = "rp"
+
+// pageDirectives are the build directives from a page's frontmatter.
+type pageDirectives struct {
+	// sanitize is whether the page's HTML is sanitized; it's true unless
+	// the page opts out.
+	sanitize bool
+	// extension, if non-empty, replaces the '.html' extension of the
+	// page's output file (e.g. "htm").
+	extension string
+}
+
+// extensionRe matches a valid 'extension' directive.
+var extensionRe = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// parseDirectives returns the build directives in a page's own frontmatter,
+// and the names of any unknown directives.
+func parseDirectives(frontmatter map[string]any) (pageDirectives, []string, error) {
+	d := pageDirectives{sanitize: true}
+	raw, ok := frontmatter[directivesKey]
+	if !ok {
+		return d, nil, nil
+	}
+	m, ok := stringMap(raw)
+	if !ok {
+		return d, nil, fmt.Errorf("'%s' must be a map, not %T", directivesKey, raw)
+	}
+	var unknown []string
+	for _, key := range slices.Sorted(maps.Keys(m)) {
+		switch v := m[key]; key {
+		case "sanitize":
+			b, ok := v.(bool)
+			if !ok {
+				return d, nil, fmt.Errorf("'%s.sanitize' must be a boolean, not %T", directivesKey, v)
+			}
+			d.sanitize = b
+		case "extension":
+			s, ok := v.(string)
+			s = strings.TrimPrefix(s, ".")
+			if !ok || !extensionRe.MatchString(s) {
+				return d, nil, fmt.Errorf("'%s.extension' must be a file extension such as \"htm\", not %v", directivesKey, v)
+			}
+			d.extension = s
+		default:
+			unknown = append(unknown, key)
+		}
+	}
+	return d, unknown, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestSanitizeDirective(t *testing.T) {
+	const body = "Text with <kbd onclick=\"go()\">raw</kbd> HTML.\n"
+	for _, tt := range []struct {
+		name, md, want string
+	}{
+		{
+			name: "sanitized",
+			md:   body,
+			want: "<p>Text with raw HTML.</p>",
+		},
+		{
+			name: "sanitize false",
+			md:   "---\nrp:\n  sanitize: false\n---\n" + body,
+			want: "<p>Text with <kbd onclick=\"go()\">raw</kbd> HTML.</p>",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildPage(t, tt.md); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
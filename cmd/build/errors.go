@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// buildError is an error that is attributed to a specific file and, where
+// known, a position within that file.
+type buildError struct {
+	File   string
+	Line   int // 1-based; 0 if unknown
+	Column int // 1-based; 0 if unknown
+	Err    error
+}
+
+func (e *buildError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %v", e.File, e.Line, e.Column, e.Err)
+	case e.Line > 0:
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	default:
+		return fmt.Sprintf("%s: %v", e.File, e.Err)
+	}
+}
+
+func (e *buildError) Unwrap() error { return e.Err }
+
+// BuildErrors is returned by build when one or more files fail; it holds
+// each of the individual errors so that callers can inspect them.
+type BuildErrors struct {
+	Msg  string  // what failed, e.g. "error rendering pages"
+	Errs []error // one per file
+}
+
+// newBuildErrors returns a *BuildErrors for the non-nil errors in errs.
+func newBuildErrors(msg string, errs []error) error {
+	be := &BuildErrors{Msg: msg}
+	for _, err := range errs {
+		if err != nil {
+			be.Errs = append(be.Errs, err)
+		}
+	}
+	return be
+}
+
+func (e *BuildErrors) Error() string {
+	return fmt.Sprintf("%s: %v", e.Msg, errors.Join(e.Errs...))
+}
+
+func (e *BuildErrors) Unwrap() []error { return e.Errs }
+
+// templateErrorRe matches the location prefix of errors returned by
+// text/template and html/template, e.g. "template: base:12:3: ...".
+var templateErrorRe = regexp.MustCompile(`template: ([^:]+):(\d+):(?:(\d+):)?`)
+
+// locateTemplateError attributes an error returned from parsing or
+// executing a template to the file that the template was loaded from. If the
+// location can't be determined, err is returned unchanged.
+func (t *templates) locateTemplateError(err error) error {
+	m := templateErrorRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	file, ok := t.paths[m[1]]
+	if !ok {
+		return err
+	}
+	line, _ := strconv.Atoi(m[2])
+	col, _ := strconv.Atoi(m[3])
+	return &buildError{File: file, Line: line, Column: col, Err: err}
+}
+
+// yamlLineRe matches the line number in errors from the YAML parser.
+var yamlLineRe = regexp.MustCompile(`^yaml: line (\d+):`)
+
+// frontmatterError attributes an error from parsing a page's frontmatter to
+// the right line of the markdown file.
+func frontmatterError(file string, err error) error {
+	be := &buildError{File: file, Err: fmt.Errorf("invalid frontmatter: %w", err)}
+	if m := yamlLineRe.FindStringSubmatch(err.Error()); m != nil {
+		// The frontmatter starts after the opening '---' line.
+		line, _ := strconv.Atoi(m[1])
+		be.Line = line + 1
+	}
+	return be
+}
+
+// attributeError attributes err to file, unless it already carries location
+// information.
+func attributeError(file string, err error) error {
+	var be *buildError
+	if err == nil || errors.As(err, &be) {
+		return err
+	}
+	return &buildError{File: file, Err: err}
+}
+
+// jsonError is the representation of a single error written by
+// -error-format=json.
+type jsonError struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// writeJSONErrors writes each non-nil error to stdout as a single line of
+// JSON, including location information where it is available.
+func writeJSONErrors(errs []error) error {
+	enc := json.NewEncoder(os.Stdout)
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		je := jsonError{Message: err.Error()}
+		var be *buildError
+		if errors.As(err, &be) {
+			je.File = be.File
+			je.Line = be.Line
+			je.Column = be.Column
+		}
+		if err := enc.Encode(je); err != nil {
+			return err
+		}
+	}
+	return nil
+}
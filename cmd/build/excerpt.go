@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// moreMarker, on a line of its own, ends a page's excerpt.
+const moreMarker = "<!--more-->"
+
+// excerptWords is the number of words in an excerpt made by truncating a
+// page that has neither a 'summary' nor a moreMarker.
+const excerptWords = 70
+
+// cutAtMore removes the first top-level moreMarker in doc, and everything
+// after it, so that rendering doc renders the page's excerpt. It reports
+// whether there was a marker.
+func cutAtMore(doc ast.Node, source []byte) bool {
+	var marker ast.Node
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		block, ok := n.(*ast.HTMLBlock)
+		if !ok {
+			continue
+		}
+		var text bytes.Buffer
+		for i := 0; i < block.Lines().Len(); i++ {
+			seg := block.Lines().At(i)
+			text.Write(seg.Value(source))
+		}
+		if strings.TrimSpace(text.String()) == moreMarker {
+			marker = n
+			break
+		}
+	}
+	if marker == nil {
+		return false
+	}
+	for n := marker; n != nil; {
+		next := n.NextSibling()
+		doc.RemoveChild(doc, n)
+		n = next
+	}
+	return true
+}
+
+// markdownify converts the markdown in s, such as a 'summary' frontmatter
+// value, to sanitized HTML.
+func (g *mdGenerator) markdownify(s string) (string, error) {
+	var buf bytes.Buffer
+	if err := g.md.Convert([]byte(s), &buf); err != nil {
+		return "", err
+	}
+	return g.pol.Sanitize(buf.String()), nil
+}
+
+// truncatedExcerpt returns the first excerptWords words of the text of the
+// HTML content as a paragraph, or "" if it has no text.
+func truncatedExcerpt(content string) template.HTML {
+	words := strings.Fields(htmlToText(content))
+	if len(words) == 0 {
+		return ""
+	}
+	text := strings.Join(words[:min(len(words), excerptWords)], " ")
+	if len(words) > excerptWords {
+		text += "…"
+	}
+	return template.HTML("<p>" + template.HTMLEscapeString(text) + "</p>")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer/html"
+)
+
+// optionalExtensions are the names of the goldmark extensions that a page
+// can choose between with the 'extensions' frontmatter key, in the order
+// that they're added to goldmark.
+var optionalExtensions = []string{"table", "abbreviations", "typographer", "footnote", "strikethrough", "tasklist"}
+
+// optionalExtension returns the goldmark extensions for one of
+// optionalExtensions.
+func optionalExtension(name string) []goldmark.Extender {
+	switch name {
+	case "table":
+		// Alignments are rendered as 'align' attributes rather than
+		// inline styles, which the sanitizer would remove.
+		return []goldmark.Extender{
+			extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+			tableCaptions,
+		}
+	case "abbreviations":
+		return []goldmark.Extender{abbreviations}
+	case "typographer":
+		return []goldmark.Extender{extension.Typographer}
+	case "footnote":
+		return []goldmark.Extender{extension.Footnote}
+	case "strikethrough":
+		return []goldmark.Extender{extension.Strikethrough}
+	case "tasklist":
+		return []goldmark.Extender{extension.TaskList}
+	}
+	return nil
+}
+
+// markdownSet creates the goldmark instances for each set of optional
+// extensions that pages use. Creating an instance is much slower than
+// converting a page, so there's only one for each set.
+type markdownSet struct {
+	// defaults are the optional extensions used by pages that don't
+	// choose their own.
+	defaults []string
+	// build creates an instance with the given optional extensions, as
+	// well as those that every page uses.
+	build func(optional []goldmark.Extender) goldmark.Markdown
+
+	mu    sync.Mutex
+	bySet map[string]goldmark.Markdown
+}
+
+// get returns the instance with the named optional extensions, or with the
+// default extensions if names is nil. With rawHTML, the instance renders raw
+// HTML in the markdown, as -allow-html does for every page.
+func (s *markdownSet) get(names []string, rawHTML bool) (goldmark.Markdown, error) {
+	if names == nil {
+		names = s.defaults
+	}
+	for _, name := range names {
+		if !slices.Contains(optionalExtensions, name) {
+			return nil, fmt.Errorf("unknown extension %q; must be one of %q", name, optionalExtensions)
+		}
+	}
+	var (
+		set      []string
+		optional []goldmark.Extender
+	)
+	for _, name := range optionalExtensions {
+		if slices.Contains(names, name) {
+			set = append(set, name)
+			optional = append(optional, optionalExtension(name)...)
+		}
+	}
+	if rawHTML {
+		set = append(set, "raw-html")
+		optional = append(optional, rawHTMLExt{})
+	}
+	key := strings.Join(set, ",")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if md, ok := s.bySet[key]; ok {
+		return md, nil
+	}
+	if s.bySet == nil {
+		s.bySet = make(map[string]goldmark.Markdown)
+	}
+	md := s.build(optional)
+	s.bySet[key] = md
+	return md, nil
+}
+
+// rawHTMLExt is a goldmark extension that renders raw HTML in markdown
+// instead of omitting it, for pages with 'sanitize: false'.
+type rawHTMLExt struct{}
+
+func (rawHTMLExt) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(html.WithUnsafe())
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// faviconSizes are the PNG icons generated by -favicons, keyed by file name.
+var faviconSizes = []struct {
+	name string
+	size int
+}{
+	{"favicon-16x16.png", 16},
+	{"favicon-32x32.png", 32},
+	{"apple-touch-icon.png", 180},
+	{"android-chrome-192x192.png", 192},
+	{"android-chrome-512x512.png", 512},
+}
+
+// faviconICOSizes are the sizes included in favicon.ico.
+var faviconICOSizes = []int{16, 32, 48}
+
+// faviconLinks returns the HTML that references the generated icons, which
+// is available to templates as .Site.Favicons.
+func faviconLinks(urls urlConfig) template.HTML {
+	return template.HTML(fmt.Sprintf(`<link rel="icon" type="image/png" sizes="32x32" href="%s">
+<link rel="icon" type="image/png" sizes="16x16" href="%s">
+<link rel="apple-touch-icon" sizes="180x180" href="%s">
+<link rel="manifest" href="%s">`,
+		template.HTMLEscapeString(urls.relURL("/favicon-32x32.png")),
+		template.HTMLEscapeString(urls.relURL("/favicon-16x16.png")),
+		template.HTMLEscapeString(urls.relURL("/apple-touch-icon.png")),
+		template.HTMLEscapeString(urls.relURL("/site.webmanifest"))))
+}
+
+// faviconFiles returns the names of all files written by generateFavicons.
+func faviconFiles() []string {
+	names := []string{"favicon.ico", "site.webmanifest"}
+	for _, icon := range faviconSizes {
+		names = append(names, icon.name)
+	}
+	return names
+}
+
+// generateFavicons resizes the image src into the standard favicon and
+// touch icon sizes, and writes them to the output root along with a web app
+// manifest. If outDir already contains icons generated from the current
+// version of src (i.e. with the same modification time), they're left as
+// they are.
+func generateFavicons(out outputWriter, outDir, src string, site *siteData) error {
+	st, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	modTime := st.ModTime()
+	if outDir != "" && faviconsUpToDate(outDir, modTime) {
+		return nil
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return fmt.Errorf("decoding %s: %w", src, err)
+	}
+	img = cropSquare(img)
+
+	for _, icon := range faviconSizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, resizeImage(img, icon.size)); err != nil {
+			return err
+		}
+		if err := out.WriteFile(icon.name, &buf, 0, modTime); err != nil {
+			return err
+		}
+	}
+
+	ico, err := encodeICO(img, faviconICOSizes)
+	if err != nil {
+		return err
+	}
+	if err := out.WriteFile("favicon.ico", bytes.NewReader(ico), 0, modTime); err != nil {
+		return err
+	}
+
+	manifest, err := webManifest(site)
+	if err != nil {
+		return err
+	}
+	return out.WriteFile("site.webmanifest", bytes.NewReader(manifest), 0, modTime)
+}
+
+// faviconsUpToDate reports whether every generated file in outDir has the
+// modification time modTime.
+func faviconsUpToDate(outDir string, modTime time.Time) bool {
+	for _, name := range faviconFiles() {
+		st, err := os.Stat(filepath.Join(outDir, name))
+		if err != nil || !st.ModTime().Equal(modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// webManifest returns a web app manifest that references the generated
+// Android icons.
+func webManifest(site *siteData) ([]byte, error) {
+	type icon struct {
+		Src   string `json:"src"`
+		Sizes string `json:"sizes"`
+		Type  string `json:"type"`
+	}
+	manifest := struct {
+		Name      string `json:"name,omitempty"`
+		ShortName string `json:"short_name,omitempty"`
+		Icons     []icon `json:"icons"`
+		Display   string `json:"display"`
+	}{
+		Name:      site.Title,
+		ShortName: site.Title,
+		Icons: []icon{
+			// Relative to the manifest, so that they work wherever
+			// the site is served from.
+			{"android-chrome-192x192.png", "192x192", "image/png"},
+			{"android-chrome-512x512.png", "512x512", "image/png"},
+		},
+		Display: "standalone",
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// encodeICO returns an ICO file containing img at each of the given sizes,
+// each stored as a PNG.
+func encodeICO(img image.Image, sizes []int) ([]byte, error) {
+	var images [][]byte
+	for _, size := range sizes {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, resizeImage(img, size)); err != nil {
+			return nil, err
+		}
+		images = append(images, buf.Bytes())
+	}
+
+	var buf bytes.Buffer
+	// ICONDIR header: reserved, type (1 = icon), and the image count.
+	binary.Write(&buf, binary.LittleEndian, [3]uint16{0, 1, uint16(len(images))})
+	offset := 6 + 16*len(images)
+	for i, size := range sizes {
+		// ICONDIRENTRY; a width or height of 0 means 256.
+		dim := uint8(size)
+		binary.Write(&buf, binary.LittleEndian, struct {
+			Width, Height, Colors, Reserved uint8
+			Planes, BitCount                uint16
+			Size, Offset                    uint32
+		}{dim, dim, 0, 0, 1, 32, uint32(len(images[i])), uint32(offset)})
+		offset += len(images[i])
+	}
+	for _, data := range images {
+		buf.Write(data)
+	}
+	return buf.Bytes(), nil
+}
+
+// cropSquare returns the largest centered square region of img.
+func cropSquare(img image.Image) image.Image {
+	b := img.Bounds()
+	if b.Dx() == b.Dy() {
+		return img
+	}
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	r := image.Rect(x0, y0, x0+side, y0+side)
+	if si, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return si.SubImage(r)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			dst.Set(x, y, img.At(x0+x, y0+y))
+		}
+	}
+	return dst
+}
+
+// resizeImage scales img to a size×size image, averaging all of the source
+// pixels that each destination pixel covers. It's only intended for
+// downscaling; when upscaling, it picks the nearest source pixel.
+func resizeImage(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		sy0 := b.Min.Y + y*b.Dy()/size
+		sy1 := max(sy0+1, b.Min.Y+(y+1)*b.Dy()/size)
+		for x := 0; x < size; x++ {
+			sx0 := b.Min.X + x*b.Dx()/size
+			sx1 := max(sx0+1, b.Min.X+(x+1)*b.Dx()/size)
+
+			// The colors are alpha-premultiplied, so they can be
+			// averaged directly.
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n),
+				G: uint16(g / n),
+				B: uint16(bl / n),
+				A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
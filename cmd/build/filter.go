@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// outputFilter is an external command that each rendered HTML page is piped
+// through before it's written, with -filter-command.
+type outputFilter struct {
+	name string
+	args []string
+}
+
+// newOutputFilter returns the filter for command, which is split into the
+// program and its arguments at spaces.
+func newOutputFilter(command string) (*outputFilter, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, errors.New("empty command")
+	}
+	if _, err := exec.LookPath(fields[0]); err != nil {
+		return nil, err
+	}
+	return &outputFilter{name: fields[0], args: fields[1:]}, nil
+}
+
+// run pipes page to the command, and returns what it writes to stdout. It's
+// an error for the command to exit with a non-zero status.
+func (f *outputFilter) run(page []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(f.name, f.args...)
+	cmd.Stdin = bytes.NewReader(page)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running %s: %w: %s", f.name, err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// flattenPath returns the name of the file at the slash-separated path p in
+// a flattened output, with its directories joined to its name by dashes,
+// e.g. "blog/post.html" becomes "blog-post.html". A leading slash is kept.
+func flattenPath(p string) string {
+	prefix := ""
+	if strings.HasPrefix(p, "/") {
+		prefix = "/"
+	}
+	return prefix + strings.ReplaceAll(strings.Trim(p, "/"), "/", "-")
+}
+
+// flatOutput is an outputWriter that writes every file to the root of the
+// underlying output, with the name given by flattenPath.
+type flatOutput struct {
+	outputWriter
+
+	mu sync.Mutex
+	// written maps each flattened name to the path it was flattened from.
+	written map[string]string
+}
+
+func newFlatOutput(out outputWriter) *flatOutput {
+	return &flatOutput{outputWriter: out, written: make(map[string]string)}
+}
+
+// WriteFile writes to the flattened name, returning an error if another
+// path has already been flattened to the same name.
+func (f *flatOutput) WriteFile(name string, r io.Reader, mode fs.FileMode, modTime time.Time) error {
+	name = path.Clean(strings.ReplaceAll(name, `\`, "/"))
+	flat := flattenPath(name)
+	f.mu.Lock()
+	if other, ok := f.written[flat]; ok && other != name {
+		f.mu.Unlock()
+		return fmt.Errorf("%s and %s are both flattened to %s", other, name, flat)
+	}
+	f.written[flat] = name
+	f.mu.Unlock()
+	return f.outputWriter.WriteFile(flat, r, mode, modTime)
+}
+
+// pageDirKey holds the slash-separated directory of the page being parsed,
+// relative to the source directory, in the parser context.
+var pageDirKey = parser.NewContextKey()
+
+// flattenLinks is a goldmark extension that rewrites the local links and
+// images in markdown to point to their flattened names; see flattenPath.
+// Relative links are resolved against the directory in pageDirKey, and
+// rewritten as site-absolute ones.
+var flattenLinks goldmark.Extender = &flattenLinksExt{}
+
+type flattenLinksExt struct{}
+
+func (e *flattenLinksExt) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(flattenLinksExt{}, 500)))
+}
+
+func (flattenLinksExt) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	dir, _ := pc.Get(pageDirKey).(string)
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Link:
+			n.Destination = flattenLink(dir, n.Destination)
+		case *ast.Image:
+			n.Destination = flattenLink(dir, n.Destination)
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// flattenLink returns the flattened form of the link dest in a page in dir.
+// Links with a scheme or host, and links within the page, are unchanged.
+func flattenLink(dir string, dest []byte) []byte {
+	u, err := url.Parse(string(dest))
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return dest
+	}
+	p := u.Path
+	if !strings.HasPrefix(p, "/") {
+		p = path.Join("/", dir, p)
+	}
+	u.Path = flattenPath(path.Clean(p))
+	return []byte(u.String())
+}
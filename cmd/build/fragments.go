@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// renderPartial renders the named partial on its own, without a layout.
+func (t *templates) renderPartial(name string, w io.Writer, data renderData, enc *charsetEncoding) error {
+	// Every layout includes every partial, so it doesn't matter which
+	// one we use; pick the first so that the result is deterministic.
+	layouts := slices.Sorted(maps.Keys(t.layouts))
+	if len(layouts) == 0 {
+		return fmt.Errorf("no layouts to render partial %q with", name)
+	}
+	tmpl := t.layouts[layouts[0]]
+	if tmpl.Lookup(name) == nil {
+		return fmt.Errorf("partial %q not found", name)
+	}
+
+	// As in render, never execute the shared template itself.
+	cloned, err := tmpl.Clone()
+	if err != nil {
+		return fmt.Errorf("cloning layout %q: %w", layouts[0], err)
+	}
+	cloned.Funcs(data.urls.funcs())
+	cloned.Funcs(partialFuncs(cloned))
+
+	var outBuf bytes.Buffer
+	if err := executeTemplate(cloned, &outBuf, name, data); err != nil {
+		return t.locateTemplateError(err)
+	}
+	_, err = w.Write(enc.transcode(outBuf.Bytes()))
+	return err
+}
+
+// writeFragments renders each of the partials listed in the config file's
+// 'fragments' to its own file, and returns the files that it wrote, mapped to
+// the partial that each was rendered from.
+func (g *mdGenerator) writeFragments(site *siteData) (map[string]string, []error) {
+	written := make(map[string]string)
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(g.cfg.Fragments)) {
+		relPath := g.cfg.Fragments[name]
+		data := renderData{
+			Path:    g.linkPath(relPath),
+			Charset: g.charset.name,
+			Site:    site,
+			urls:    g.urls,
+		}
+		var buf bytes.Buffer
+		if err := g.tmpls.renderPartial(name, &buf, data, g.charset); err != nil {
+			err = fmt.Errorf("fragment %s: %w", relPath, err)
+			if file, ok := g.tmpls.paths[name]; ok {
+				err = attributeError(file, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		if err := writeOutputBytes(g.out, relPath, buf.Bytes()); err != nil {
+			errs = append(errs, fmt.Errorf("error writing fragment %s: %w", relPath, err))
+			continue
+		}
+		written[relPath] = name
+	}
+	return written, errs
+}
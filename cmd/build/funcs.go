@@ -0,0 +1,445 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// templateFuncs returns all of the additional functions that are available
+// to templates.
+func templateFuncs(includeDir, dataDir string, urls urlConfig, cfg *siteConfig) template.FuncMap {
+	funcs := template.FuncMap{}
+	maps.Copy(funcs, fileFuncs(includeDir))
+	maps.Copy(funcs, dataFuncs(dataDir))
+	maps.Copy(funcs, pageListFuncs())
+	maps.Copy(funcs, cfg.timeFuncs())
+	maps.Copy(funcs, partialFuncs(nil))
+	maps.Copy(funcs, urls.funcs())
+	return funcs
+}
+
+// urlConfig determines how URLs are generated.
+type urlConfig struct {
+	// baseURL is the absolute URL that the site is served from, or empty
+	// if it's unknown.
+	baseURL string
+	// trailingSlash is the value of the -trailing-slash flag: "always",
+	// "never" or "auto".
+	trailingSlash string
+	// flatten is whether the output is flattened (see flattenPath), so
+	// that paths must be too.
+	flatten bool
+	// prefix is the -output-prefix that every file is written under
+	// (e.g. "docs"), which is added to every path, or empty.
+	prefix string
+}
+
+// pageURL is the site-absolute URL of a generated page, such as a page's
+// .URL. It already includes the output prefix, so absURL and relURL don't
+// add it again, as they do for other paths.
+type pageURL string
+
+// funcs returns the template functions that depend on the URL configuration:
+//
+//	absURL "/about" -> "https://example.com/about"
+//	relURL "/about" -> "/about", prefixed with the base URL's path, if any
+//
+// Both take a path or a pageURL. Templates are parsed with the site-wide
+// configuration; each page is then rendered with the functions for its own
+// base URL, which may differ (see the 'baseURL' frontmatter key).
+func (u urlConfig) funcs() template.FuncMap {
+	return template.FuncMap{
+		"absURL": func(v any) (string, error) {
+			s, isPage, err := urlArg(v)
+			return u.resolveAbs(s, isPage), err
+		},
+		"relURL": func(v any) (string, error) {
+			s, isPage, err := urlArg(v)
+			return u.resolveRel(s, isPage), err
+		},
+	}
+}
+
+// urlArg returns the path or URL passed to absURL or relURL, and whether
+// it's a pageURL.
+func urlArg(v any) (s string, isPage bool, err error) {
+	switch v := v.(type) {
+	case string:
+		return v, false, nil
+	case pageURL:
+		return string(v), true, nil
+	default:
+		return "", false, fmt.Errorf("expected a path or URL, not %T", v)
+	}
+}
+
+// absURL resolves the site-absolute or relative path s against the base URL.
+// If s is already an absolute URL, it's returned unchanged; if there's no
+// base URL, the result is site-absolute.
+func (u urlConfig) absURL(s string) string { return u.resolveAbs(s, false) }
+
+// absPageURL is absURL for the URL of a generated page.
+func (u urlConfig) absPageURL(p pageURL) string { return u.resolveAbs(string(p), true) }
+
+// relURL returns the site-absolute path for the path s, including the path
+// of the base URL if the site isn't served from the root of its host.
+func (u urlConfig) relURL(s string) string { return u.resolveRel(s, false) }
+
+func (u urlConfig) resolveAbs(s string, isPage bool) string {
+	if pu, err := url.Parse(s); err == nil && pu.IsAbs() {
+		return s
+	}
+	return strings.TrimSuffix(u.baseURL, "/") + u.sitePath(s, isPage)
+}
+
+func (u urlConfig) resolveRel(s string, isPage bool) string {
+	if pu, err := url.Parse(s); err == nil && pu.IsAbs() {
+		return s
+	}
+	prefix := ""
+	if bu, err := url.Parse(u.baseURL); err == nil {
+		prefix = strings.TrimSuffix(bu.Path, "/")
+	}
+	return prefix + u.sitePath(s, isPage)
+}
+
+// sitePath returns s as a site-absolute path, with its trailing slash fixed
+// and, with -flatten, flattened, under the output prefix. The URLs of pages
+// (isPage) are already flattened and prefixed, so they're only fixed.
+func (u urlConfig) sitePath(s string, isPage bool) string {
+	if isPage {
+		return u.fixSlash("/" + strings.TrimPrefix(s, "/"))
+	}
+	p := u.fixSlash("/" + strings.TrimPrefix(s, "/"))
+	if u.flatten {
+		suffix := ""
+		if i := strings.IndexAny(p, "?#"); i >= 0 {
+			p, suffix = p[:i], p[i:]
+		}
+		p = flattenPath(p) + suffix
+	}
+	if u.prefix == "" {
+		return p
+	}
+	return u.fixSlash("/" + u.prefixed(strings.TrimPrefix(p, "/")))
+}
+
+// prefixed returns the slash-separated path p, relative to the output root,
+// under the output prefix.
+func (u urlConfig) prefixed(p string) string {
+	if u.prefix == "" {
+		return p
+	}
+	return u.prefix + "/" + p
+}
+
+// fixSlash adds or removes the trailing slash of a URL path according to
+// the -trailing-slash mode. Paths whose last element has an extension (e.g.
+// "/css/main.css") are never given one.
+func (u urlConfig) fixSlash(p string) string {
+	suffix := ""
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p, suffix = p[:i], p[i:]
+	}
+	switch u.trailingSlash {
+	case "always":
+		if p != "" && !strings.HasSuffix(p, "/") && path.Ext(path.Base(p)) == "" {
+			p += "/"
+		}
+	case "never":
+		if p != "/" {
+			p = strings.TrimSuffix(p, "/")
+		}
+	}
+	return p + suffix
+}
+
+// partialFuncs returns the template function that executes a partial from
+// tmpl whose name is only known when the page is rendered:
+//
+//	partial (printf "_cards/%s" .Section) . -> the partial's output
+//
+// Templates are parsed with a nil tmpl; each page is then rendered with the
+// functions for the template that's rendering it.
+func partialFuncs(tmpl *template.Template) template.FuncMap {
+	return template.FuncMap{
+		"partial": func(name string, data any) (template.HTML, error) {
+			if tmpl == nil {
+				return "", errors.New("partial can only be called while rendering a page")
+			}
+			t := tmpl.Lookup(name)
+			if t == nil {
+				return "", fmt.Errorf("partial %q not found", name)
+			}
+			var buf strings.Builder
+			if err := t.Execute(&buf, data); err != nil {
+				return "", err
+			}
+			return template.HTML(buf.String()), nil
+		},
+	}
+}
+
+// fileFuncs returns the template functions that inline the contents of files
+// found under root:
+//
+//	readFile "css/critical.css"  -> the file contents as a string
+//	includeHTML "icons/logo.svg" -> the file contents as trusted HTML
+//
+// includeHTML does not escape its output, so it should only be used for
+// trusted files such as SVG icons.
+func fileFuncs(root string) template.FuncMap {
+	return template.FuncMap{
+		"readFile": func(name string) (string, error) {
+			data, err := readFileUnder(root, name)
+			return string(data), err
+		},
+		"includeHTML": func(name string) (template.HTML, error) {
+			data, err := readFileUnder(root, name)
+			return template.HTML(data), err
+		},
+	}
+}
+
+// dataFuncs returns the template functions that read structured data from
+// files found under root:
+//
+//	data "team.yaml"  -> the parsed contents, e.g. a map[string]any or []any
+//
+// Files ending in ".json" are parsed as JSON, and those ending in ".yaml" or
+// ".yml" as YAML. Each file is only read and parsed once per build.
+func dataFuncs(root string) template.FuncMap {
+	var (
+		mu    sync.Mutex
+		cache = make(map[string]any)
+	)
+	return template.FuncMap{
+		"data": func(name string) (any, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if v, ok := cache[name]; ok {
+				return v, nil
+			}
+			b, err := readFileUnder(root, name)
+			if err != nil {
+				return nil, err
+			}
+			var v any
+			switch path.Ext(name) {
+			case ".json":
+				err = json.Unmarshal(b, &v)
+			case ".yaml", ".yml":
+				err = yaml.Unmarshal(b, &v)
+				v = jsonValue(v)
+			default:
+				return nil, fmt.Errorf("data file %q must be .json, .yaml or .yml", name)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("parsing data file %q: %w", name, err)
+			}
+			cache[name] = v
+			return v, nil
+		},
+	}
+}
+
+// readFileUnder reads the file name, interpreted relative to root. It
+// returns an error if the path would escape root, either lexically or by
+// following a symlink.
+func readFileUnder(root, name string) ([]byte, error) {
+	if root == "" {
+		return nil, errors.New("no directory configured to read files from")
+	}
+
+	// Allow site-absolute paths like "/css/main.css", but nothing that
+	// could escape the root (e.g. "../secret").
+	rel := filepath.FromSlash(strings.TrimPrefix(name, "/"))
+	if !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("path %q is outside of %s", name, root)
+	}
+
+	absRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	full, err := filepath.EvalSymlinks(filepath.Join(absRoot, rel))
+	if err != nil {
+		return nil, err
+	}
+	if r, err := filepath.Rel(absRoot, full); err != nil || !filepath.IsLocal(r) {
+		return nil, fmt.Errorf("path %q is outside of %s", name, root)
+	}
+	return os.ReadFile(full)
+}
+
+// pageGroup is a set of pages that share the same value for some field, as
+// returned by the groupBy template function.
+type pageGroup struct {
+	Key   string
+	Pages []*pageRef
+}
+
+// pageListFuncs returns template functions that operate on lists of pages,
+// such as .Site.Pages:
+//
+//	groupBy .Site.Pages "year"     -> pages grouped by the year of their date
+//	groupBy .Site.Pages "category" -> pages grouped by a frontmatter key
+//	sortBy .Site.Pages "date" "desc" -> a sorted copy of the pages
+//	first 5 .Site.Pages            -> at most the first 5 pages
+//	last 5 .Site.Pages             -> at most the last 5 pages
+func pageListFuncs() template.FuncMap {
+	return template.FuncMap{
+		"groupBy": groupBy,
+		"sortBy":  sortBy,
+		"first": func(n int, pages []*pageRef) []*pageRef {
+			return pages[:max(0, min(n, len(pages)))]
+		},
+		"last": func(n int, pages []*pageRef) []*pageRef {
+			return pages[len(pages)-max(0, min(n, len(pages))):]
+		},
+	}
+}
+
+// sortBy returns a copy of pages, stably sorted by the given field: one of
+// "date", "title", "weight" or "url", or the name of a frontmatter key. The
+// optional order is "asc" (the default) or "desc". Pages that don't have the
+// field always come last, in their original order.
+func sortBy(pages []*pageRef, field string, order ...string) ([]*pageRef, error) {
+	desc := false
+	switch {
+	case len(order) == 0:
+	case len(order) == 1 && (order[0] == "asc" || order[0] == "desc"):
+		desc = order[0] == "desc"
+	default:
+		return nil, fmt.Errorf("sortBy: invalid order %q; must be \"asc\" or \"desc\"", order)
+	}
+
+	value := func(p *pageRef) (any, bool) {
+		switch field {
+		case "date":
+			return p.Date, !p.Date.IsZero()
+		case "title":
+			return p.Title, p.Title != ""
+		case "weight":
+			return p.Weight, p.hasWeight
+		case "url":
+			return string(p.URL), true
+		default:
+			v, ok := p.Params[field]
+			return v, ok && v != nil
+		}
+	}
+
+	ret := slices.Clone(pages)
+	sort.SliceStable(ret, func(i, j int) bool {
+		vi, oki := value(ret[i])
+		vj, okj := value(ret[j])
+		if !oki || !okj {
+			return oki && !okj
+		}
+		c := compareValues(vi, vj)
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	return ret, nil
+}
+
+// compareValues compares two field values for sortBy. Times and numbers are
+// compared by value, and anything else by its string representation.
+func compareValues(a, b any) int {
+	if ta, ok := a.(time.Time); ok {
+		if tb, ok := b.(time.Time); ok {
+			return ta.Compare(tb)
+		}
+	}
+	if na, ok := toFloat(a); ok {
+		if nb, ok := toFloat(b); ok {
+			return cmp.Compare(na, nb)
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// groupBy groups pages by the given field, which is either "year" or "month"
+// (of the page's date), or the name of a frontmatter key. Pages that don't
+// have the field are omitted; a page whose frontmatter value is a list is
+// included in the group for each item.
+//
+// Groups by "year" and "month" are ordered newest first, and all other groups
+// are ordered by key. Within a group, pages keep their original order.
+func groupBy(pages []*pageRef, field string) []pageGroup {
+	var (
+		keys   []string
+		groups = make(map[string][]*pageRef)
+	)
+	add := func(key string, p *pageRef) {
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], p)
+	}
+
+	for _, p := range pages {
+		switch field {
+		case "year", "month":
+			if p.Date.IsZero() {
+				continue
+			}
+			layout := "2006"
+			if field == "month" {
+				layout = "2006-01"
+			}
+			add(p.Date.Format(layout), p)
+		default:
+			v, ok := p.Params[field]
+			if !ok || v == nil {
+				continue
+			}
+			if list, ok := v.([]any); ok {
+				for _, item := range list {
+					add(fmt.Sprint(item), p)
+				}
+			} else {
+				add(fmt.Sprint(v), p)
+			}
+		}
+	}
+
+	if field == "year" || field == "month" {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	} else {
+		sort.Strings(keys)
+	}
+	ret := make([]pageGroup, len(keys))
+	for i, key := range keys {
+		ret[i] = pageGroup{Key: key, Pages: groups[key]}
+	}
+	return ret
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// funcSets are named sets of template functions that are only available to
+// pages that opt into them with the 'funcs' frontmatter key, e.g.
+//
+//	funcs: [strings]
+//
+// This keeps specialized helpers out of the functions that every template
+// sees. To add a set, add it here; its function names must not collide with
+// the built-in functions or with those of any other set.
+var funcSets = map[string]template.FuncMap{
+	"strings": {
+		"upper":     strings.ToUpper,
+		"lower":     strings.ToLower,
+		"replace":   strings.ReplaceAll,
+		"trim":      strings.TrimSpace,
+		"split":     strings.Split,
+		"join":      func(sep string, s []string) string { return strings.Join(s, sep) },
+		"hasPrefix": strings.HasPrefix,
+		"hasSuffix": strings.HasSuffix,
+	},
+}
+
+// funcSetPlaceholders returns a placeholder for every function in funcSets,
+// to be added to the built-in functions. Templates are parsed before we know
+// which page they'll render, so every function must exist at parse time;
+// render replaces the placeholders with the real functions for pages that
+// use their set, and otherwise calling one is an error.
+//
+// It returns an error if a function name is defined by more than one set,
+// or by a set and builtins.
+func funcSetPlaceholders(builtins template.FuncMap) (template.FuncMap, error) {
+	ret := template.FuncMap{}
+	owner := make(map[string]string)
+	for _, set := range slices.Sorted(maps.Keys(funcSets)) {
+		for name := range funcSets[set] {
+			if _, ok := builtins[name]; ok {
+				return nil, fmt.Errorf("function %q in func set %q collides with a built-in function", name, set)
+			}
+			if other, ok := owner[name]; ok {
+				return nil, fmt.Errorf("function %q is defined by both func sets %q and %q", name, other, set)
+			}
+			owner[name] = set
+			ret[name] = func(...any) (string, error) {
+				return "", fmt.Errorf("function %q is only available to pages with %q in their 'funcs' frontmatter", name, set)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// pageFuncs returns the functions in the named sets, or an error if one of
+// them doesn't exist.
+func pageFuncs(sets []string) (template.FuncMap, error) {
+	if len(sets) == 0 {
+		return nil, nil
+	}
+	ret := template.FuncMap{}
+	for _, set := range sets {
+		funcs, ok := funcSets[set]
+		if !ok {
+			return nil, fmt.Errorf("unknown func set %q; available sets are %s", set, strings.Join(slices.Sorted(maps.Keys(funcSets)), ", "))
+		}
+		maps.Copy(ret, funcs)
+	}
+	return ret, nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// imageInliner replaces the src of small local images in converted pages
+// with data URIs, to save a request for each; see -inline-images-below.
+type imageInliner struct {
+	// maxSize is the size in bytes that images must be smaller than to
+	// be inlined.
+	maxSize int64
+	// sourceDir and staticDir are where images are looked for. Relative
+	// paths are relative to the page's directory in sourceDir, and
+	// site-absolute paths are looked for in sourceDir and then staticDir.
+	sourceDir string
+	staticDir string
+}
+
+// inline returns content, the HTML of the page at relSrc, with its small
+// local images inlined. Images that can't be found or read are left alone.
+func (in *imageInliner) inline(content []byte, relSrc string) []byte {
+	var out bytes.Buffer
+	changed := false
+	z := html.NewTokenizer(bytes.NewReader(content))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		raw := z.Raw()
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			out.Write(raw)
+			continue
+		}
+
+		// Copy the raw bytes, since reading the token reuses them.
+		raw = slices.Clone(raw)
+		tok := z.Token()
+		i := slices.IndexFunc(tok.Attr, func(a html.Attribute) bool { return a.Key == "src" })
+		if tok.DataAtom != atom.Img || i < 0 {
+			out.Write(raw)
+			continue
+		}
+		uri, ok := in.dataURI(tok.Attr[i].Val, relSrc)
+		if !ok {
+			out.Write(raw)
+			continue
+		}
+		tok.Attr[i].Val = uri
+		out.WriteString(tok.String())
+		changed = true
+	}
+	if !changed {
+		return content
+	}
+	return out.Bytes()
+}
+
+// dataURI returns the data URI for the image src in the page at relSrc, if
+// it's a local image smaller than the maximum size.
+func (in *imageInliner) dataURI(src, relSrc string) (string, bool) {
+	u, err := url.Parse(src)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+	typ := mime.TypeByExtension(strings.ToLower(path.Ext(u.Path)))
+	typ, _, _ = strings.Cut(typ, ";")
+	if !strings.HasPrefix(typ, "image/") {
+		return "", false
+	}
+
+	var candidates [][2]string // root, name
+	if strings.HasPrefix(u.Path, "/") {
+		candidates = append(candidates, [2]string{in.sourceDir, u.Path})
+		if in.staticDir != "" {
+			candidates = append(candidates, [2]string{in.staticDir, u.Path})
+		}
+	} else {
+		name := path.Join(path.Dir(filepath.ToSlash(relSrc)), u.Path)
+		candidates = append(candidates, [2]string{in.sourceDir, name})
+	}
+	for _, c := range candidates {
+		root, name := c[0], strings.TrimPrefix(c[1], "/")
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			continue
+		}
+		st, err := os.Stat(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil || !st.Mode().IsRegular() {
+			continue
+		}
+		if st.Size() >= in.maxSize {
+			return "", false
+		}
+		data, err := readFileUnder(root, name)
+		if err != nil {
+			log.Printf("warning: not inlining image %s: %v", src, err)
+			return "", false
+		}
+		return "data:" + typ + ";base64," + base64.StdEncoding.EncodeToString(data), true
+	}
+	return "", false
+}
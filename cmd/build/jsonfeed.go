@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"slices"
+	"time"
+)
+
+// jsonFeedName is the name of the JSON Feed written with -jsonfeed.
+const jsonFeedName = "feed.json"
+
+// jsonFeed is a JSON Feed (see https://jsonfeed.org/version/1.1).
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string `json:"id"`
+	URL           string `json:"url"`
+	Title         string `json:"title,omitempty"`
+	ContentHTML   string `json:"content_html"`
+	DatePublished string `json:"date_published"`
+}
+
+// jsonFeedJSON returns a JSON Feed of every page with a date, newest first.
+// Pages can opt out of the feed with 'feed: false' in their frontmatter.
+// URLs are resolved against urls, which must have a base URL.
+func jsonFeedJSON(site *siteData, pages []*page, urls urlConfig) ([]byte, error) {
+	var dated []*page
+	for _, p := range pages {
+		if include, ok := p.ref.Params["feed"].(bool); ok && !include {
+			continue
+		}
+		if !p.ref.Date.IsZero() {
+			dated = append(dated, p)
+		}
+	}
+	slices.SortStableFunc(dated, func(a, b *page) int {
+		return b.ref.Date.Compare(a.ref.Date)
+	})
+
+	title := site.Title
+	if title == "" {
+		title = "Site"
+	}
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       title,
+		Description: site.Description,
+		HomePageURL: urls.absURL("/"),
+		FeedURL:     urls.absURL("/" + jsonFeedName),
+		Items:       make([]jsonFeedItem, 0, len(dated)),
+	}
+	for _, p := range dated {
+		url := p.ref.Permalink
+		if url == "" {
+			url = urls.absPageURL(p.ref.URL)
+		}
+		content, _ := p.data.Content.(template.HTML)
+		feed.Items = append(feed.Items, jsonFeedItem{
+			ID:            url,
+			URL:           url,
+			Title:         p.ref.Title,
+			ContentHTML:   string(content),
+			DatePublished: p.ref.Date.Format(time.RFC3339),
+		})
+	}
+
+	// Content is HTML, so don't escape it further.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
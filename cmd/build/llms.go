@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// llmsTxt generates the contents of an llms.txt file (see
+// https://llmstxt.org) listing every page in the site, in canonical order.
+// Pages can opt out of the listing with 'llms: false' in their frontmatter.
+func llmsTxt(site *siteData) []byte {
+	var buf bytes.Buffer
+	title := site.Title
+	if title == "" {
+		title = "Site"
+	}
+	fmt.Fprintf(&buf, "# %s\n\n", title)
+	if site.Description != "" {
+		fmt.Fprintf(&buf, "> %s\n\n", oneLine(site.Description))
+	}
+
+	buf.WriteString("## Pages\n\n")
+	for _, p := range site.Pages {
+		if include, ok := p.Params["llms"].(bool); ok && !include {
+			continue
+		}
+		title := p.Title
+		if title == "" {
+			title = string(p.URL)
+		}
+		fmt.Fprintf(&buf, "- [%s](%s)", oneLine(title), p.URL)
+		if description, ok := p.Params["description"].(string); ok && description != "" {
+			fmt.Fprintf(&buf, ": %s", oneLine(description))
+		}
+		buf.WriteString("\n")
+	}
+	return buf.Bytes()
+}
+
+// oneLine collapses all whitespace in s, including newlines, into single
+// spaces.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
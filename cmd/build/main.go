@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"flag"
 	"fmt"
@@ -9,31 +10,254 @@ import (
 	"io"
 	"io/fs"
 	"log"
+	"maps"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/microcosm-cc/bluemonday"
 	"github.com/yuin/goldmark"
 	meta "github.com/yuin/goldmark-meta"
-	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/ast"
 	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
 )
 
 var (
-	templateDir    = flag.String("template-dir", "templates", "Directory containing templates; defaults to 'templates' next to sourcedir")
+	templateDir    = flag.String("template-dir", "templates", "Directory containing templates, which override the built-in templates with the same name; if empty, 'templates' next to sourcedir. If this is not set and the directory doesn't exist, only the built-in templates are used")
 	staticDir      = flag.String("static-dir", "", "Directory containing static files that are copied to the output directory")
 	withExtensions = flag.Bool("with-extensions", true, "Include file extensions when generating HTML")
+	linkExtensions = flag.Bool("link-extensions", true, "Include the .html extension in page paths and links exposed to templates; when false, files keep .html on disk but links omit it")
+	outputPrefix   = flag.String("output-prefix", "", "Subdirectory of the output (e.g. 'docs') that every file is written to, for sharing an output directory or bucket with other sites; links and URLs include it")
 	cleanOutput    = flag.Bool("clean-output", true, "Clean output directory before generating files")
+	headingTitle   = flag.Bool("title-from-heading", false, "Use the text of the first level 1 heading as the title of pages without a 'title' in their frontmatter")
+	requireTitle   = flag.Bool("require-title", false, "Fail the build if any page has no title, from its frontmatter or (with -title-from-heading) its first heading")
+	preserveTimes  = flag.Bool("preserve-timestamps", false, "Give each rendered page, and its JSON sidecar, the modification time of its markdown file instead of the build time")
+	debugComments  = flag.Bool("debug-comments", false, "Wrap the output of each partial, and of each page's content, in HTML comments naming where it came from")
+	bundleAssets   = flag.Bool("bundle", true, "Concatenate the files passed to the bundle template function into one file; with -bundle=false, it links to each file instead")
+	minifyBundles  = flag.Bool("minify-bundles", false, "Remove comments and unnecessary whitespace from CSS bundles")
+	dataDirFlag    = flag.String("data-dir", "", "Directory that the data template function reads JSON and YAML files from; defaults to 'data' next to sourcedir")
+	includeDir     = flag.String("include-dir", "", "Directory that the readFile and includeHTML template functions read from; defaults to -static-dir")
+	configDump     = flag.Bool("config-dump", false, "Print the effective configuration, from the flags (including defaults) and the -config file, as YAML, then exit without building; no arguments are needed")
+	configFile     = flag.String("config", "", "Path to an optional YAML site configuration file")
+	errorFormat    = flag.String("error-format", "text", "Format of build errors; either 'text' or 'json' (one object per line on stdout)")
+	charset        = flag.String("charset", "utf-8", "Character encoding of generated pages; can be overridden per page with the 'charset' frontmatter key")
+	traceBuild     = flag.Bool("trace", false, "Print a breakdown of how long each phase of the build took")
+	traceOut       = flag.String("trace-out", "", "If set, write a Go runtime/trace execution trace to this file")
+	traceSlow      = flag.Duration("trace-slow", 250*time.Millisecond, "With -trace, highlight files that take longer than this to convert")
+	metaTable      = flag.Bool("meta-table", false, "Render each page's frontmatter as a table at the top of the page")
+	strict         = flag.Bool("strict", false, "Treat warnings (e.g. invalid frontmatter) as build errors; see README")
+	archivePath    = flag.String("archive", "", "Write the output to this .zip, .tar or .tar.gz archive instead of a directory; the outdir argument is then omitted")
+	inlineImages   = flag.Int64("inline-images-below", 0, "Inline local images smaller than this many bytes into pages as data URIs; 0 disables inlining")
+	taskLists      = flag.Bool("task-lists", false, "Render list items starting with '[ ]' or '[x]' as checkboxes, and expose them to templates as .Tasks")
+	typographer    = flag.Bool("typographer", false, "Render straight quotes as curly quotes, '--' and '---' as en and em dashes, and '...' as an ellipsis")
+	hardWraps      = flag.Bool("hard-wraps", false, "Render single newlines in markdown paragraphs as <br> line breaks")
+	allowHTML      = flag.Bool("allow-html", false, "Pass raw HTML in markdown through to the sanitizer instead of omitting it; see README for the security implications")
+	iframeDomains  = flag.String("allow-iframe-domains", "", "With -allow-html, a comma-separated list of hosts that <iframe> elements may embed content from")
+	showChanges    = flag.Bool("show-changes", false, "After building, log which output files were added, modified or removed compared to the previous build")
+	maxDepth       = flag.Int("max-depth", -1, "Maximum directory depth to descend into under sourcedir; 0 means only files directly in sourcedir, and a negative value means no limit")
+	genLLMSTxt     = flag.Bool("llms-txt", false, "Write an llms.txt index of all pages to the output root; pages can opt out with 'llms: false' in their frontmatter")
+	metaPrecedence = flag.String("metadata-precedence", "frontmatter", "Which value wins when a page's frontmatter and its metadata file (e.g. post.md.yaml) both set a key: 'frontmatter' or 'file'")
+	noFrontmatter  = flag.String("no-frontmatter", "ignore", "How to handle pages without frontmatter: 'ignore' treats them like any other page, 'derive' applies the config file's plain_defaults and takes the title from the first heading or the file name, and 'error' fails the build")
+	verbose        = flag.Bool("verbose", false, "Log more detail about each page, such as the title derived for a page without frontmatter")
+	genAnchors     = flag.Bool("anchors", false, "Write anchors.json to the output root, mapping the URL of every page to the IDs of its headings")
+	genJSONFeed    = flag.Bool("jsonfeed", false, "Write a JSON Feed of every page with a date to feed.json in the output root; requires -base-url, and pages can opt out with 'feed: false' in their frontmatter")
+	validateOnly   = flag.Bool("validate", false, "Check that all layouts parse and render with empty data, then exit without building; the outdir argument may be omitted")
+	tocMaxDepth    = flag.Int("toc-max-depth", 3, "Maximum heading level included in a page's table of contents (e.g. from a [[TOC]] placeholder)")
+	cleanOrphans   = flag.Bool("clean-orphans", false, "Instead of cleaning the output directory before building, remove only the files that the build didn't produce afterwards")
+	filterCommand  = flag.String("filter-command", "", "Pipe each rendered HTML page through this command (e.g. 'html-minifier --collapse-whitespace'), and write its output instead; arguments are separated by spaces")
+	readonlySource = flag.Bool("verify-readonly-source", false, "Fail if anything would be written into the source directory, and if the source directory changed during the build")
+	profilePages   = flag.Int("profile-pages", 0, "After building, log the N pages that took the longest to convert and render, with the time spent parsing, rendering and sanitizing markdown and rendering templates")
+	checkHTML      = flag.Bool("validate-html", false, "Check each generated HTML page for mismatched and unclosed tags, reported as warnings (errors with -strict)")
+	cleanOnlyFlag  = flag.Bool("clean-only", false, "Clean the output directory as the build would, then exit without building")
+	since          = flag.String("since", "", "Only render markdown files modified after this time, or whose metadata file (e.g. post.md.yaml) was; either a duration (e.g. '10m') or a timestamp. Implies -clean-output=false")
+	baseURL        = flag.String("base-url", "", "Absolute URL that the site is served from (e.g. 'https://example.com/'), used for canonical links and the absURL template function; pages can override it with 'baseURL' in their frontmatter. With 'auto', it's detected from -base-url-env or the git remote where possible")
+	baseURLEnv     = flag.String("base-url-env", "CI_PAGES_URL", "Comma-separated environment variables to take the base URL from with -base-url=auto, in order of preference")
+	emitJSON       = flag.Bool("emit-json", false, "Write a .json sidecar next to each page with its frontmatter, title, path and reading time")
+	favicons       = flag.String("favicons", "", "Path to a square source image (PNG, JPEG or GIF) to generate favicons, touch icons and a site.webmanifest from; templates can include the links to them with .Site.Favicons")
+	schemaFile     = flag.String("schema", "", "Path to an optional YAML file declaring the frontmatter keys that pages may have; violations are warnings, or errors with -strict. See README")
+	trailingSlash  = flag.String("trailing-slash", "auto", "With -link-extensions=false, whether links to pages end with a slash: 'always' (e.g. /about/ and /docs/), 'never' (/about and /docs), or 'auto' (/about and /docs/index)")
+	cpuProfile     = flag.String("cpuprofile", "", "If set, write a CPU profile of the build to this file")
+	memProfile     = flag.String("memprofile", "", "If set, write a heap profile to this file at the end of the build")
+	mermaidCLI     = flag.String("mermaid", "", "Render 'mermaid' code blocks to SVG at build time with this Mermaid CLI command (e.g. 'mmdc'); if it's unavailable, they're rendered for mermaid.js instead")
+	katexCLI       = flag.String("katex", "", "Render 'math' code blocks to HTML at build time with this KaTeX CLI command (e.g. 'katex'); if it's unavailable, they're rendered for KaTeX's auto-render instead")
+	renderCache    = flag.String("render-cache", "", "Directory in which to cache the output of -mermaid and -katex, keyed by a hash of each block")
+	fileMode       = flag.String("file-mode", "0644", "Octal permissions of generated files, and of copied files with -preserve-mode=false")
+	dirMode        = flag.String("dir-mode", "0755", "Octal permissions of directories created in the output directory")
+	preserveMode   = flag.Bool("preserve-mode", true, "Copy static and other non-markdown files with the permissions of the source file, instead of -file-mode")
+	baseTemplate   = flag.String("base-template", "base", "Name of the template that's executed to render each layout; a layout that doesn't define it is executed via its own top-level content")
+	buildDrafts    = flag.Bool("drafts", false, "Build pages with 'draft: true' in their frontmatter")
+	buildFuture    = flag.Bool("future", false, "Build pages whose 'date' is after the build time")
+	buildExpired   = flag.Bool("expired", false, "Build pages whose 'expiryDate' is at or before the build time")
+	buildTime      = flag.String("build-time", "", "Time to compare page dates and expiry dates to, in any frontmatter date format, instead of the current time; for reproducible builds")
+	flatten        = flag.Bool("flatten", false, "Write every file to the root of the output, naming it after its path (e.g. blog/post.md becomes blog-post.html), and rewrite links to match")
+	authorsFile    = flag.String("authors", "", "Path to an optional YAML file mapping each author's name to their 'bio', 'website' and any other information, for bylines and author pages")
+	authorPages    = flag.Bool("author-pages", false, "Generate a page for each author at authors/<slug>.html, rendered with the 'author' layout")
+	defaultLayout  = flag.String("default-layout", "", "If set, render pages whose 'layout' doesn't exist with this layout instead, with a warning (an error with -strict)")
+	cacheDir       = flag.String("cache-dir", "", "If set, cache converted markdown in this directory (e.g. '.rp-cache'), and reuse it in later builds for files that haven't changed")
+	dryRender      = flag.Bool("dry-render", false, "Convert and render every page as usual, but discard the output instead of writing it, to check for errors; the outdir argument may be omitted")
+	partialPrefix  = flag.String("partial-prefix", "_", "Prefix added to the names of partials whose file names don't already start with '_', e.g. 'partials/'; may be empty, so that partials are named after their files")
+	sharedPartials = flag.String("shared-partials", "", "Directory of partials shared between sites, loaded before those in the template directory; a local partial with the same name overrides the shared one")
+	cspMode        = flag.Bool("csp", false, "Move inline styles in rendered pages to an external stylesheet, and write a Content-Security-Policy header allowing their inline scripts to csp.txt")
+	readStdin      = flag.Bool("stdin", false, "Read a single markdown page from stdin and write it, rendered, to stdout; the sourcedir argument is optional and only used for context such as cascades, and there is no outdir")
+	stdinLayout    = flag.String("layout", "", "With -stdin, the layout to render the page with, overriding its 'layout' frontmatter")
+	serveAddr      = flag.String("serve", "", "After building, serve the output directory on this address (e.g. 'localhost:8080') until interrupted")
+	serveSPA       = flag.Bool("serve-spa", false, "With -serve, serve the root index.html for paths that don't exist, for client-side routers")
+	serveDirList   = flag.Bool("serve-dirlist", false, "With -serve, list the contents of directories that don't have an index.html")
 )
 
 func main() {
 	flag.Parse()
-	if flag.NArg() != 2 {
-		log.Fatal("usage: %s sourcedir outdir", os.Args[0])
+	if *configDump {
+		if err := dumpConfig(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	var outDir string
+	switch {
+	case *readStdin && flag.NArg() <= 1:
+		// The page is written to stdout.
+	case (*validateOnly || *dryRender) && (flag.NArg() == 1 || flag.NArg() == 2):
+		// We don't write any output.
+	case *archivePath != "" && flag.NArg() == 1:
+		// No output directory; all paths are relative to the archive root.
+	case *archivePath == "" && flag.NArg() == 2:
+		outDir = flag.Arg(1)
+	default:
+		log.Fatalf("usage: %s sourcedir outdir\n       %s -archive out.tar.gz sourcedir\n       %s -stdin [sourcedir] < page.md", os.Args[0], os.Args[0], os.Args[0])
 	}
 	sourceDir := flag.Arg(0)
-	outDir := flag.Arg(1)
+	if *serveAddr != "" && (*archivePath != "" || *validateOnly || *dryRender) {
+		log.Fatalf("-serve cannot be used with -archive, -validate or -dry-render")
+	}
+	if *readStdin && (*serveAddr != "" || *archivePath != "" || *validateOnly || *dryRender || *cleanOrphans || *showChanges) {
+		log.Fatalf("-stdin cannot be used with -serve, -archive, -validate, -dry-render, -clean-orphans or -show-changes")
+	}
+	if *cleanOnlyFlag {
+		if outDir == "" || *serveAddr != "" || *readStdin || *validateOnly || *dryRender {
+			log.Fatalf("-clean-only requires an outdir, and cannot be used with -serve, -stdin, -validate or -dry-render")
+		}
+		if err := cleanOnly(sourceDir, outDir); err != nil {
+			log.Fatalf("error cleaning output directory: %v", err)
+		}
+		return
+	}
+	if *stdinLayout != "" && !*readStdin {
+		log.Fatalf("-layout requires -stdin")
+	}
+	if *flatten && *cleanOrphans {
+		log.Fatalf("-flatten cannot be used with -clean-orphans")
+	}
+	if *dryRender && (*archivePath != "" || *cleanOrphans || *showChanges) {
+		log.Fatalf("-dry-render cannot be used with -archive, -clean-orphans or -show-changes")
+	}
+	if !slices.Contains([]string{"ignore", "derive", "error"}, *noFrontmatter) {
+		log.Fatalf("invalid -no-frontmatter %q; must be 'ignore', 'derive' or 'error'", *noFrontmatter)
+	}
+	if *metaPrecedence != "frontmatter" && *metaPrecedence != "file" {
+		log.Fatalf("invalid -metadata-precedence %q; must be 'frontmatter' or 'file'", *metaPrecedence)
+	}
+	if *errorFormat != "text" && *errorFormat != "json" {
+		log.Fatalf("invalid -error-format %q; must be 'text' or 'json'", *errorFormat)
+	}
+
+	var sourceHashes map[string][sha256.Size]byte
+	if *readonlySource && sourceDir != "" {
+		var err error
+		if sourceHashes, err = hashTree(sourceDir); err != nil {
+			log.Fatalf("error hashing source directory: %v", err)
+		}
+	}
+	err := build(sourceDir, outDir)
+	if sourceHashes != nil {
+		if err := checkSourceUnchanged(sourceDir, sourceHashes); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err != nil {
+		var be *BuildErrors
+		if errors.As(err, &be) {
+			fatalErrors(be.Msg, be.Errs)
+		}
+		log.Fatal(err)
+	}
+
+	if *serveAddr != "" {
+		log.Printf("serving %s on http://%s/", outDir, *serveAddr)
+		log.Fatal(http.ListenAndServe(*serveAddr, &serveHandler{
+			root:    outDir,
+			spa:     *serveSPA,
+			dirList: *serveDirList,
+		}))
+	}
+}
+
+// build generates the site from the markdown files in sourceDir, writing the
+// output to outDir (or to the -archive file). Rather than exiting, it returns
+// any error; when individual files fail, that's a *BuildErrors holding one
+// error per file.
+func build(sourceDir, outDir string) error {
+	var guard *readonlyGuard
+	if *readonlySource {
+		var err error
+		if guard, err = newReadonlyGuard(sourceDir); err != nil {
+			return fmt.Errorf("error resolving source directory: %w", err)
+		}
+		for _, w := range []struct{ path, what string }{
+			{outDir, "the output directory"},
+			{*archivePath, "the archive"},
+			{*cacheDir, "the build cache"},
+			{*renderCache, "the render cache"},
+			{*traceOut, "the trace"},
+			{*cpuProfile, "the CPU profile"},
+			{*memProfile, "the heap profile"},
+		} {
+			if err := guard.check(w.path, w.what); err != nil {
+				return err
+			}
+		}
+	}
+	if *traceOut != "" {
+		f, err := os.Create(*traceOut)
+		if err != nil {
+			return fmt.Errorf("error creating trace file: %w", err)
+		}
+		defer f.Close()
+		if err := trace.Start(f); err != nil {
+			return fmt.Errorf("error starting trace: %w", err)
+		}
+		defer trace.Stop()
+	}
+	if *cpuProfile != "" {
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			return fmt.Errorf("error creating CPU profile: %w", err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return fmt.Errorf("error starting CPU profile: %w", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+	if *memProfile != "" {
+		defer writeHeapProfile(*memProfile)
+	}
+	var tracer *buildTracer
+	if *traceBuild {
+		tracer = newBuildTracer(*traceSlow)
+	}
 
 	// Parse templates
 	tdir := *templateDir
@@ -42,95 +266,513 @@ func main() {
 	}
 	tdir, err := filepath.Abs(tdir)
 	if err != nil {
-		log.Fatalf("error getting absolute path for %s: %v", tdir, err)
+		return fmt.Errorf("error getting absolute path for %s: %w", tdir, err)
 	}
-	if st, err := os.Stat(tdir); err != nil || !st.IsDir() {
-		log.Fatalf("template directory %s does not exist or is not a directory", tdir)
-	} else {
+	if st, err := os.Stat(tdir); err == nil && st.IsDir() {
 		log.Printf("using templates from %s", tdir)
+	} else if isFlagSet("template-dir") && *templateDir != "" {
+		return fmt.Errorf("template directory %s does not exist or is not a directory", tdir)
+	} else {
+		// Without a template directory, we only use the built-in
+		// templates.
+		log.Printf("using built-in templates")
+		tdir = ""
 	}
 
-	tmpls, err := loadTemplates(tdir)
+	if *baseURL == "auto" {
+		*baseURL = ""
+		var envVars []string
+		if *baseURLEnv != "" {
+			envVars = strings.Split(*baseURLEnv, ",")
+		}
+		if u, source := detectBaseURL(envVars, *staticDir, sourceDir); u != "" {
+			log.Printf("using base URL %s from %s", u, source)
+			*baseURL = u
+		} else {
+			log.Printf("couldn't detect the base URL; not using one")
+		}
+	}
+	if *baseURL != "" {
+		if err := checkBaseURL(*baseURL); err != nil {
+			return fmt.Errorf("invalid -base-url: %w", err)
+		}
+	}
+	switch *trailingSlash {
+	case "auto":
+	case "always", "never":
+		if *linkExtensions {
+			return fmt.Errorf("-trailing-slash=%s requires -link-extensions=false", *trailingSlash)
+		}
+	default:
+		return fmt.Errorf("invalid -trailing-slash %q; must be 'always', 'never' or 'auto'", *trailingSlash)
+	}
+	if *flatten && *trailingSlash != "auto" {
+		return fmt.Errorf("-flatten cannot be used with -trailing-slash=%s", *trailingSlash)
+	}
+	if *cspMode && *since != "" {
+		return errors.New("-csp cannot be used with -since, since csp-styles.css and csp.txt must cover pages that aren't rendered")
+	}
+	if *genJSONFeed && *baseURL == "" {
+		return errors.New("-jsonfeed requires -base-url, since feeds must use absolute URLs")
+	}
+	prefix, err := parseOutputPrefix(*outputPrefix)
 	if err != nil {
-		log.Fatalf("error loading templates: %v", err)
+		return err
 	}
+	urls := urlConfig{baseURL: *baseURL, trailingSlash: *trailingSlash, flatten: *flatten, prefix: prefix}
 
-	// Clean output directory
-	if *cleanOutput {
-		if err := cleanDirectory(outDir); err != nil {
-			log.Fatalf("error cleaning output directory: %v", err)
+	// With -output-prefix, everything that's done to the output
+	// directory, including cleaning it, only affects the prefix
+	// directory.
+	if prefix != "" && outDir != "" {
+		outDir = filepath.Join(outDir, filepath.FromSlash(prefix))
+	}
+
+	incDir := *includeDir
+	if incDir == "" {
+		incDir = *staticDir
+	}
+	endPhase := tracer.phase("load templates")
+	dataDir := *dataDirFlag
+	if dataDir == "" {
+		dataDir = filepath.Join(filepath.Dir(sourceDir), "data")
+	}
+	// The config is loaded first, since some template functions use it.
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	funcs := templateFuncs(incDir, dataDir, urls, cfg)
+	placeholders, err := funcSetPlaceholders(funcs)
+	if err != nil {
+		return err
+	}
+	maps.Copy(funcs, placeholders)
+	bundles := &bundler{root: *staticDir, urls: urls, enabled: *bundleAssets, minify: *minifyBundles}
+	maps.Copy(funcs, bundles.funcs())
+	if *debugComments {
+		funcs[debugCommentFunc] = debugComment
+	}
+	tmpls, err := loadTemplates(newTemplateFS(tdir), *sharedPartials, *partialPrefix, funcs, *baseTemplate, *debugComments)
+	if err != nil {
+		return fmt.Errorf("error loading templates: %w", err)
+	}
+	endPhase()
+
+	if *validateOnly {
+		if errs := tmpls.validate(); len(errs) > 0 {
+			return newBuildErrors("error validating templates", errs)
+		}
+		log.Printf("all %d layouts are valid", len(tmpls.layouts))
+		return nil
+	}
+
+	keep, err := newPreserveSet(cfg.Preserve)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	if err := tmpls.addAliases(cfg.LayoutAliases); err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	for name, v := range cfg.Variants {
+		if _, ok := tmpls.layouts[v.Layout]; !ok {
+			return fmt.Errorf("error loading config: variant %q has layout %q, which doesn't exist", name, v.Layout)
+		}
+	}
+
+	schema, err := loadSchema(*schemaFile)
+	if err != nil {
+		return fmt.Errorf("error loading schema: %w", err)
+	}
+
+	defaultCharset, err := lookupCharset(*charset)
+	if err != nil {
+		return fmt.Errorf("invalid -charset: %w", err)
+	}
+
+	// If we're only building recently-changed files, parse the cutoff time.
+	var sinceTime time.Time
+	if *since != "" {
+		sinceTime, err = parseSince(*since, time.Now())
+		if err != nil {
+			return fmt.Errorf("error parsing -since: %w", err)
+		}
+		log.Printf("only converting files modified after %s", sinceTime.Format(time.RFC3339))
+	}
+
+	// Record the state of the previous build's output, so that we can
+	// report what changed.
+	var prevHashes map[string][sha256.Size]byte
+	if *showChanges {
+		if *archivePath != "" {
+			return errors.New("-show-changes cannot be used with -archive")
+		}
+		prevHashes, err = hashTree(outDir)
+		if err != nil {
+			return fmt.Errorf("error hashing previous output: %w", err)
+		}
+	}
+
+	// Clean output directory; we skip this when using -since, since the
+	// output for files that we don't convert must be left untouched.
+	if *cleanOrphans && *archivePath != "" {
+		return errors.New("-clean-orphans cannot be used with -archive")
+	}
+	if outDir != "" && !*dryRender && (*cleanOutput || *cleanOrphans) {
+		if err := checkCleanDir(outDir, sourceDir, *staticDir); err != nil {
+			return err
 		}
 	}
+	if *cleanOutput && !*cleanOrphans && !*dryRender && !*readStdin && sinceTime.IsZero() && *archivePath == "" {
+		// The prefix directory doesn't exist until the first build
+		// that writes to it.
+		if _, err := os.Stat(outDir); prefix == "" || !errors.Is(err, fs.ErrNotExist) {
+			if err := cleanDirectory(outDir, keep); err != nil {
+				return fmt.Errorf("error cleaning output directory: %w", err)
+			}
+		}
+	}
+
+	var modes outputModes
+	if modes.file, err = parseMode(*fileMode); err != nil {
+		return fmt.Errorf("invalid -file-mode: %w", err)
+	}
+	if modes.dir, err = parseMode(*dirMode); err != nil {
+		return fmt.Errorf("invalid -dir-mode: %w", err)
+	}
+	var out outputWriter = discardOutput{}
+	if !*dryRender && !*readStdin {
+		out, err = newOutputWriter(outDir, *archivePath, modes)
+		if err != nil {
+			return fmt.Errorf("error creating output: %w", err)
+		}
+		if prefix != "" && *archivePath != "" {
+			out = prefixOutput{out, prefix}
+		}
+		if guard != nil && *archivePath == "" {
+			out = guardedOutput{out, outDir, guard}
+		}
+	}
+	// If the build fails before the output is finished, abort it, so
+	// that a partial archive isn't left behind.
+	closed := false
+	defer func() {
+		if !closed {
+			out.Abort()
+		}
+	}()
+	if *flatten {
+		out = newFlatOutput(out)
+	}
 
-	md := goldmark.New(
-		goldmark.WithExtensions(
-			meta.Meta,
-			extension.Table,
-		),
-	)
+	var metaOpts []meta.Option
+	if *metaTable {
+		metaOpts = append(metaOpts, meta.WithTable())
+	}
+	var rendererOpts []renderer.Option
+	if *hardWraps {
+		rendererOpts = append(rendererOpts, html.WithHardWraps())
+	}
+	var sanitizeOpts sanitizeOptions
+	if *allowHTML {
+		rendererOpts = append(rendererOpts, html.WithUnsafe())
+		if *iframeDomains != "" {
+			sanitizeOpts.iframeDomains = strings.Split(*iframeDomains, ",")
+		}
+	}
+	diagramCommands := make(map[string]string)
+	if *mermaidCLI != "" {
+		diagramCommands["mermaid"] = *mermaidCLI
+	}
+	if *katexCLI != "" {
+		diagramCommands["math"] = *katexCLI
+	}
+	diagrams := newDiagramRenderer(diagramCommands, *renderCache)
+	markdowns := &markdownSet{
+		defaults: []string{"table", "abbreviations"},
+		build: func(optional []goldmark.Extender) goldmark.Markdown {
+			exts := []goldmark.Extender{meta.New(metaOpts...)}
+			exts = append(exts, optional...)
+			exts = append(exts, codeBlocks(diagrams), blockAttributes, alerts)
+			if *flatten {
+				exts = append(exts, flattenLinks)
+			}
+			return goldmark.New(
+				goldmark.WithExtensions(exts...),
+				goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+				goldmark.WithRendererOptions(rendererOpts...),
+			)
+		},
+	}
+	if *typographer {
+		markdowns.defaults = append(markdowns.defaults, "typographer")
+	}
+	if *taskLists {
+		markdowns.defaults = append(markdowns.defaults, "tasklist")
+	}
+	md, err := markdowns.get(nil, false)
+	if err != nil {
+		return err
+	}
 	gen := &mdGenerator{
-		md:    md,
-		tmpls: tmpls,
-		pol:   bluemonday.UGCPolicy(),
+		md:        md,
+		markdowns: markdowns,
+		tmpls:     tmpls,
+		pol:       newSanitizePolicy(sanitizeOpts),
+		cfg:       cfg,
+		out:       out,
+		linkExt:   *linkExtensions,
+		charset:   defaultCharset,
+		strict:    *strict,
+		staticDir: *staticDir,
+		tocDepth:  *tocMaxDepth,
+		sourceDir: sourceDir,
+		urls:      urls,
+		schema:    schema,
+		diagrams:  diagrams,
+	}
+	if *cspMode {
+		gen.csp = newCSPCollector(urls)
+	}
+	gen.preserveTimes = *preserveTimes
+	gen.checkHTML = *checkHTML
+	if *profilePages > 0 {
+		gen.profile = newPageProfiler()
+	}
+	if *filterCommand != "" {
+		if gen.filter, err = newOutputFilter(*filterCommand); err != nil {
+			return fmt.Errorf("invalid -filter-command: %w", err)
+		}
+	}
+	gen.titleFromHeading = *headingTitle
+	gen.requireTitle = *requireTitle
+	gen.noFrontmatter = *noFrontmatter
+	gen.verbose = *verbose
+	gen.metaFileWins = *metaPrecedence == "file"
+	if *inlineImages > 0 {
+		gen.images = &imageInliner{maxSize: *inlineImages, sourceDir: sourceDir, staticDir: *staticDir}
+		if gen.csp != nil {
+			gen.csp.dataImages = true
+		}
+	}
+	gen.publish = publishOptions{now: time.Now(), drafts: *buildDrafts, future: *buildFuture, expired: *buildExpired}
+	if *buildTime != "" {
+		if gen.publish.now, err = cfg.parseDate(*buildTime); err != nil {
+			return fmt.Errorf("invalid -build-time: %w", err)
+		}
+	}
+	if gen.authorInfo, err = loadAuthors(*authorsFile); err != nil {
+		return fmt.Errorf("error loading authors: %w", err)
+	}
+	if *authorPages {
+		if _, ok := tmpls.layouts[authorLayout]; !ok {
+			return fmt.Errorf("-author-pages requires an %q layout", authorLayout)
+		}
+		gen.authorPages = true
+	}
+	if *defaultLayout != "" {
+		if _, ok := tmpls.layouts[*defaultLayout]; !ok {
+			return fmt.Errorf("-default-layout %q not found", *defaultLayout)
+		}
+		gen.defaultLayout = *defaultLayout
+	}
+	if *cacheDir != "" {
+		if gen.cache, err = newBuildCache(*cacheDir); err != nil {
+			return fmt.Errorf("error opening build cache: %w", err)
+		}
+	}
+
+	// Load the frontmatter that cascades from section indexes to the pages
+	// beneath them.
+	if sourceDir != "" {
+		gen.cascades, err = gen.loadCascades()
+		if err != nil {
+			return newBuildErrors("error loading section indexes", []error{err})
+		}
+	}
+
+	if *readStdin {
+		return gen.renderStdin(os.Stdin, os.Stdout, *stdinLayout)
 	}
 
 	// Walk the source directory and generate the output. In the case where
 	// copying or generating a file results in an error, we store the error
 	// and return nil to keep walking; this ensures that we discover as
 	// many errors as possible, instead of exiting on the first one.
+	//
+	// outputs records every file that we write (relative to the output
+	// directory), mapped to the file that produced it.
 	var renderErrs []error
+	var pages []*page
+	outputs := make(map[string]string)
+	endPhase = tracer.phase("convert")
 	err = filepath.WalkDir(sourceDir, func(path string, info fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
+		relPath, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return fmt.Errorf("error getting relative path for %s: %w", path, err)
+		}
 		if info.IsDir() {
+			// The files in a directory have a depth equal to the
+			// number of path components in the directory's path.
+			if *maxDepth >= 0 && relPath != "." && strings.Count(relPath, string(filepath.Separator))+1 > *maxDepth {
+				return filepath.SkipDir
+			}
 			return nil // nothing to do; keep recursing
 		}
 
-		// If the file is not a markdown file, just copy it to the output directory
+		// If the file is not a markdown file, just copy it to the output
+		// directory, unless it's the metadata file for a page.
 		if filepath.Ext(path) != ".md" {
+			if isMetadataFile(path) {
+				return nil
+			}
 			log.Printf("copying %s", path)
-			dst := filepath.Join(outDir, path[len(sourceDir):])
-			if err := copyFile(path, dst); err != nil {
-				renderErrs = append(renderErrs, fmt.Errorf("error copying %s to %s: %w", path, dst, err))
+			if err := copyFile(out, path, relPath, *preserveMode); err != nil {
+				renderErrs = append(renderErrs, &buildError{
+					File: path,
+					Err:  fmt.Errorf("error copying to %s: %w", filepath.Join(outDir, relPath), err),
+				})
 				return nil
 			}
+			outputs[relPath] = path
 			return nil
 		}
 
-		// Convert the markdown file to HTML in the same directory
-		// structure
-		relPath, err := filepath.Rel(sourceDir, path)
-		if err != nil {
-			return fmt.Errorf("error getting relative path for %s: %w", path, err)
+		// Markdown files that haven't changed since the cutoff, and
+		// whose metadata files haven't either, are still converted, so
+		// that they're included in listings, but aren't rendered.
+		render := true
+		if !sinceTime.IsZero() {
+			fi, err := info.Info()
+			if err != nil {
+				return fmt.Errorf("error getting file info for %s: %w", path, err)
+			}
+			modTime := fi.ModTime()
+			metaTime, err := metadataModTime(path)
+			if err != nil {
+				return fmt.Errorf("error getting file info for the metadata of %s: %w", path, err)
+			}
+			if metaTime.After(modTime) {
+				modTime = metaTime
+			}
+			render = modTime.After(sinceTime)
 		}
 
-		// Change the '.md' extension to '.html'
-		relPath = relPath[:len(relPath)-len(filepath.Ext(relPath))]
-		if *withExtensions {
-			relPath = relPath + ".html"
+		relSrc := relPath
+		relPath = pageOutputPath(relPath)
+		if other, ok := outputs[relPath]; ok {
+			renderErrs = append(renderErrs, &buildError{
+				File: path,
+				Err:  fmt.Errorf("output %s is also generated from %s", relPath, other),
+			})
+			return nil
+		}
+		// Pages are linked to by their directory (e.g. /about/), which
+		// only resolves to index pages.
+		if urls.trailingSlash == "always" && strings.TrimSuffix(filepath.Base(relPath), ".html") != "index" {
+			renderErrs = append(renderErrs, &buildError{
+				File: path,
+				Err:  fmt.Errorf("-trailing-slash=always requires directory-style pages, e.g. %s instead of %s", filepath.Join(strings.TrimSuffix(relSrc, ".md"), "index.md"), relSrc),
+			})
+			return nil
 		}
 
-		// Ensure the destination directory exists
 		fullDest := filepath.Join(outDir, relPath)
-		if err := os.MkdirAll(filepath.Dir(fullDest), 0755); err != nil {
-			return fmt.Errorf("error creating directory for %s: %w", fullDest, err)
+		if render {
+			log.Printf("converting %s -> %s", path, fullDest)
 		}
-
-		log.Printf("converting %s -> %s", path, filepath.Join(outDir, relPath))
-		if err := gen.convertMarkdownFile(outDir, relPath, path); err != nil {
+		endFile := tracer.file(path)
+		p, err := gen.convertMarkdownFile(relPath, path)
+		endFile()
+		if err != nil {
 			renderErrs = append(renderErrs, fmt.Errorf("error converting %s to %s: %w", path, fullDest, err))
 			return nil
 		}
+		if p == nil {
+			return nil
+		}
+		p.render = render
+		for _, o := range p.outputs {
+			if other, ok := outputs[o.relPath]; ok && o.relPath != relPath {
+				renderErrs = append(renderErrs, &buildError{
+					File: path,
+					Err:  fmt.Errorf("%s output %s is also generated from %s", o.format, o.relPath, other),
+				})
+				return nil
+			}
+		}
+		pages = append(pages, p)
+		for _, o := range p.outputs {
+			outputs[o.relPath] = path
+		}
 		return nil
 	})
+	endPhase()
 	if err != nil || len(renderErrs) > 0 {
 		renderErrs = append([]error{err}, renderErrs...)
-		log.Fatalf("error walking source directory: %v", errors.Join(renderErrs...))
+		return newBuildErrors("error walking source directory", renderErrs)
+	}
+
+	// Now that we've converted every page, render them all with their
+	// layouts.
+	endPhase = tracer.phase("render")
+	site := cfg.site()
+	site.BuildTime = gen.publish.now
+	if *favicons != "" {
+		site.Favicons = faviconLinks(urls)
+	}
+	for _, p := range pages {
+		site.Pages = append(site.Pages, p.ref)
+	}
+	sortPages(site.Pages)
+	site.Authors = gen.collectAuthors(site.Pages)
+	gen.resolveBreadcrumbs(pages)
+	for _, p := range pages {
+		if !p.render {
+			continue
+		}
+		endFile := tracer.file(p.src)
+		err := gen.renderPage(p, site)
+		endFile()
+		if err != nil {
+			renderErrs = append(renderErrs, fmt.Errorf("error rendering %s to %s: %w", p.src, filepath.Join(outDir, p.relPath), err))
+		}
+
+		if *emitJSON {
+			name := sidecarPath(p.relPath)
+			if other, ok := outputs[name]; ok {
+				renderErrs = append(renderErrs, &buildError{
+					File: p.src,
+					Err:  fmt.Errorf("JSON sidecar %s is also generated from %s", name, other),
+				})
+				continue
+			}
+			data, err := sidecarJSON(p)
+			if err == nil {
+				var modTime time.Time
+				if *preserveTimes {
+					modTime = p.modTime
+				}
+				err = out.WriteFile(name, bytes.NewReader(data), 0, modTime)
+			}
+			if err != nil {
+				renderErrs = append(renderErrs, fmt.Errorf("error writing JSON sidecar for %s: %w", p.src, err))
+				continue
+			}
+			outputs[name] = p.src
+		}
+	}
+	endPhase()
+	if len(renderErrs) > 0 {
+		return newBuildErrors("error rendering pages", renderErrs)
 	}
 
 	// Copy all static files to the output directory
 	if *staticDir != "" {
+		endPhase := tracer.phase("copy static")
 		var copyErrors []error
 		err = filepath.Walk(*staticDir, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
@@ -147,160 +789,578 @@ func main() {
 			dst := filepath.Join(outDir, relPath)
 
 			log.Printf("copying %s -> %s", path, dst)
-			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-				copyErrors = append(copyErrors, fmt.Errorf("error creating directory for %s: %v", dst, err))
-				return nil
-			}
-			if err := copyFile(path, dst); err != nil {
-				copyErrors = append(copyErrors, fmt.Errorf("error copying %s to %s: %v", path, dst, err))
+			if err := copyFile(out, path, relPath, *preserveMode); err != nil {
+				copyErrors = append(copyErrors, &buildError{
+					File: path,
+					Err:  fmt.Errorf("error copying to %s: %w", dst, err),
+				})
 				return nil
 			}
+			outputs[relPath] = path
 			return nil
 		})
 		if err != nil || len(copyErrors) > 0 {
 			copyErrors = append([]error{err}, copyErrors...)
-			log.Fatalf("error walking static directory: %v", errors.Join(copyErrors...))
+			return newBuildErrors("error walking static directory", copyErrors)
 		}
+		endPhase()
 	}
 
-	log.Printf("done")
-}
+	// Render a page for each author.
+	if gen.authorPages && len(site.Authors) > 0 {
+		endPhase := tracer.phase("author pages")
+		var errs []error
+		for _, a := range site.Authors {
+			if other, ok := outputs[filepath.FromSlash(gen.authorPath(a))]; ok {
+				errs = append(errs, fmt.Errorf("author page %s for %q is also generated from %s", gen.authorPath(a), a.Name, other))
+			}
+		}
+		if len(errs) > 0 {
+			return newBuildErrors("error rendering author pages", errs)
+		}
+		written, errs := gen.writeAuthorPages(site)
+		for relPath, name := range written {
+			outputs[filepath.FromSlash(relPath)] = "author " + name
+		}
+		if len(errs) > 0 {
+			return newBuildErrors("error rendering author pages", errs)
+		}
+		endPhase()
+	}
 
-func copyFile(src, dst string) error {
-	// Copy the file contents, mode, and times
-	fi, err := os.Stat(src)
-	if err != nil {
-		return err
+	// Render any partials that are also standalone fragments.
+	if len(cfg.Fragments) > 0 {
+		endPhase := tracer.phase("fragments")
+		var errs []error
+		for name, relPath := range cfg.Fragments {
+			if other, ok := outputs[filepath.FromSlash(relPath)]; ok {
+				errs = append(errs, fmt.Errorf("fragment %s from %s is also generated from %s", relPath, name, other))
+			}
+		}
+		if len(errs) > 0 {
+			return newBuildErrors("error rendering fragments", errs)
+		}
+		written, errs := gen.writeFragments(site)
+		for relPath, name := range written {
+			outputs[filepath.FromSlash(relPath)] = name
+		}
+		if len(errs) > 0 {
+			return newBuildErrors("error rendering fragments", errs)
+		}
+		endPhase()
 	}
-	f, err := os.Open(src)
-	if err != nil {
-		return err
+
+	// Generate favicons from the source image.
+	if *favicons != "" {
+		endPhase := tracer.phase("favicons")
+		for _, name := range faviconFiles() {
+			if other, ok := outputs[name]; ok {
+				return fmt.Errorf("favicon %s is also generated from %s", name, other)
+			}
+		}
+		if err := generateFavicons(out, outDir, *favicons, site); err != nil {
+			return fmt.Errorf("error generating favicons: %w", err)
+		}
+		for _, name := range faviconFiles() {
+			outputs[name] = *favicons
+		}
+		endPhase()
 	}
-	defer f.Close()
 
-	df, err := os.Create(dst)
-	if err != nil {
-		return err
+	// Write the bundles that the rendered pages used.
+	for _, name := range bundles.written() {
+		if other, ok := outputs[filepath.FromSlash(name)]; ok {
+			return fmt.Errorf("bundle %s is also generated from %s", name, other)
+		}
+		outputs[filepath.FromSlash(name)] = "bundle"
+	}
+	if err := bundles.writeBundles(out); err != nil {
+		return fmt.Errorf("error writing bundles: %w", err)
 	}
-	defer df.Close()
 
-	if _, err := io.Copy(df, f); err != nil {
-		return err
+	// Write the stylesheet and policy collected from the rendered pages.
+	if gen.csp != nil {
+		for name, data := range map[string][]byte{
+			cspStylesheet: gen.csp.stylesheet(),
+			cspFile:       gen.csp.policy(),
+		} {
+			if other, ok := outputs[name]; ok {
+				return fmt.Errorf("%s generated by -csp is also generated from %s", name, other)
+			}
+			if err := writeOutputBytes(out, name, data); err != nil {
+				return fmt.Errorf("error writing %s: %w", name, err)
+			}
+			outputs[name] = "-csp"
+		}
 	}
 
-	if err := df.Close(); err != nil {
-		return err
+	// Write the llms.txt index.
+	if *genLLMSTxt {
+		if err := writeOutputBytes(out, "llms.txt", llmsTxt(site)); err != nil {
+			return fmt.Errorf("error writing llms.txt: %w", err)
+		}
+		outputs["llms.txt"] = "-llms-txt"
 	}
 
-	if err := os.Chmod(dst, fi.Mode()); err != nil {
-		return err
+	// Write the JSON collections.
+	if err := writeCollections(out, cfg.Collections, pages, sourceDir, urls, outputs); err != nil {
+		return fmt.Errorf("error writing collections: %w", err)
 	}
 
-	return os.Chtimes(dst, fi.ModTime(), fi.ModTime())
-}
+	// Write the index of anchors.
+	if *genAnchors {
+		if other, ok := outputs[anchorsName]; ok {
+			return fmt.Errorf("%s generated by -anchors is also generated from %s", anchorsName, other)
+		}
+		data, err := anchorsJSON(pages)
+		if err != nil {
+			return fmt.Errorf("error generating %s: %w", anchorsName, err)
+		}
+		if err := writeOutputBytes(out, anchorsName, data); err != nil {
+			return fmt.Errorf("error writing %s: %w", anchorsName, err)
+		}
+		outputs[anchorsName] = "-anchors"
+	}
 
-var skipCleanFilenames = map[string]bool{
-	".gitignore": true,
-}
+	// Write the JSON Feed.
+	if *genJSONFeed {
+		if other, ok := outputs[jsonFeedName]; ok {
+			return fmt.Errorf("%s generated by -jsonfeed is also generated from %s", jsonFeedName, other)
+		}
+		data, err := jsonFeedJSON(site, pages, urls)
+		if err != nil {
+			return fmt.Errorf("error generating %s: %w", jsonFeedName, err)
+		}
+		if err := writeOutputBytes(out, jsonFeedName, data); err != nil {
+			return fmt.Errorf("error writing %s: %w", jsonFeedName, err)
+		}
+		outputs[jsonFeedName] = "-jsonfeed"
+	}
 
-// cleanDirectory will remove the contents of the given directory, but without
-// removing the directory itself or certain files in the root of the directory.
-func cleanDirectory(dir string) error {
-	rootDir, err := os.Open(dir)
-	if err != nil {
-		return err
+	// Write redirects for all page aliases, now that we know every other
+	// file in the output.
+	if len(gen.aliases) > 0 {
+		endPhase := tracer.phase("write aliases")
+		if errs := writeAliases(out, gen.aliases, outputs); len(errs) > 0 {
+			return newBuildErrors("error writing aliases", errs)
+		}
+		for _, a := range gen.aliases {
+			outputs[a.relPath] = a.src
+		}
+		endPhase()
 	}
-	defer rootDir.Close()
 
-	entries, err := rootDir.Readdirnames(-1)
-	if err != nil {
-		return err
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error finishing output: %w", err)
 	}
+	closed = true
 
-	for _, entry := range entries {
-		if skipCleanFilenames[entry] {
-			continue
+	if *cleanOrphans {
+		if err := removeOrphans(outDir, outputs, keep); err != nil {
+			return fmt.Errorf("error removing orphaned output files: %w", err)
 		}
+	}
 
-		path := filepath.Join(dir, entry)
-		log.Printf("cleaning: %s", path)
-		if err := os.RemoveAll(path); err != nil {
-			return err
+	if *showChanges {
+		hashes, err := hashTree(outDir)
+		if err != nil {
+			return fmt.Errorf("error hashing output: %w", err)
 		}
+		logChanges(prevHashes, hashes)
 	}
+
+	tracer.report()
+	gen.profile.report(*profilePages)
+	if *dryRender {
+		log.Printf("rendered %d files without writing any output", len(outputs))
+	}
+	log.Printf("done")
 	return nil
 }
 
-type templates struct {
-	// layouts contains parsed layout templates, keyed by their name
-	// (without file extensions).
-	layouts map[string]*template.Template
-
-	// funcs is the set of additional functions that we make available to
-	// templates.
+// fatalErrors reports the given errors in the format chosen by the
+// -error-format flag, and then exits.
+func fatalErrors(msg string, errs []error) {
+	if *errorFormat == "json" {
+		if err := writeJSONErrors(errs); err != nil {
+			log.Printf("error writing errors: %v", err)
+		}
+		os.Exit(1)
+	}
+	log.Fatalf("%s: %v", msg, errors.Join(errs...))
+}
+
+// checkBaseURL returns an error if s isn't an absolute http(s) URL.
+func checkBaseURL(s string) error {
+	u, err := url.Parse(s)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%q is not an absolute http or https URL", s)
+	}
+	return nil
+}
+
+// writeHeapProfile writes a heap profile to the file fpath, logging any
+// error.
+func writeHeapProfile(fpath string) {
+	f, err := os.Create(fpath)
+	if err != nil {
+		log.Printf("error creating heap profile: %v", err)
+		return
+	}
+	defer f.Close()
+
+	// Get up-to-date statistics.
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		log.Printf("error writing heap profile: %v", err)
+	}
+}
+
+// isFlagSet reports whether the named flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// pageOutputPath returns the output path of the markdown file with the
+// source-relative path relPath. Pages are converted to HTML in the same
+// directory structure, changing the '.md' extension to '.html' (or removing
+// it, with -with-extensions=false). Section indexes are rendered as the index
+// page of their directory.
+func pageOutputPath(relPath string) string {
+	if filepath.Base(relPath) == sectionIndexName {
+		relPath = filepath.Join(filepath.Dir(relPath), "index.md")
+	}
+	relPath = relPath[:len(relPath)-len(filepath.Ext(relPath))]
+	if *withExtensions {
+		relPath = relPath + ".html"
+	}
+	return relPath
+}
+
+// parseOutputPrefix validates the value of the -output-prefix flag, and
+// returns it as a clean slash-separated path, or "" if it's empty.
+func parseOutputPrefix(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	prefix := path.Clean(filepath.ToSlash(s))
+	if !filepath.IsLocal(s) || prefix == "." || strings.ContainsAny(prefix, "?#") {
+		return "", fmt.Errorf("invalid -output-prefix %q; must be a subdirectory of the output", s)
+	}
+	return prefix, nil
+}
+
+// parseSince parses the value of the -since flag, which is either a duration
+// relative to now or an absolute timestamp.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range defaultDateFormats {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration nor a timestamp", s)
+}
+
+// copyFile copies the file src to the output at name, preserving its
+// modification time and, if preserveMode is set, its mode.
+func copyFile(out outputWriter, src, name string, preserveMode bool) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var mode fs.FileMode
+	if preserveMode {
+		mode = fi.Mode()
+	}
+	return out.WriteFile(name, f, mode, fi.ModTime())
+}
+
+var skipCleanFilenames = map[string]bool{
+	".gitignore": true,
+}
+
+// cleanDirectory will remove the contents of the given directory, but without
+// removing the directory itself, certain files in the root of the directory,
+// or anything that keep preserves.
+func cleanDirectory(dir string, keep preserveSet) error {
+	return cleanTree(dir, "", keep)
+}
+
+// cleanTree cleans the directory at the slash-separated path rel in dir for
+// cleanDirectory. Subdirectories are emptied first if keep might preserve
+// something in them, and only removed if nothing was.
+func cleanTree(dir, rel string, keep preserveSet) error {
+	entries, err := os.ReadDir(filepath.Join(dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := path.Join(rel, entry.Name())
+		if rel == "" && skipCleanFilenames[name] || keep.preserves(name, entry.IsDir()) {
+			continue
+		}
+
+		full := filepath.Join(dir, filepath.FromSlash(name))
+		if entry.IsDir() && len(keep) > 0 {
+			if err := cleanTree(dir, name, keep); err != nil {
+				return err
+			}
+			if rest, err := os.ReadDir(full); err != nil {
+				return err
+			} else if len(rest) > 0 {
+				continue
+			}
+		}
+		log.Printf("cleaning: %s", full)
+		if err := os.RemoveAll(full); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeOrphans removes every file in dir that isn't in outputs (which is
+// keyed by path relative to dir), other than those that cleanDirectory also
+// preserves. Directories left empty are removed.
+func removeOrphans(dir string, outputs map[string]string, keep preserveSet) error {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && keep.preserves(filepath.ToSlash(rel), d.IsDir()) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if rel != "." {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		if _, ok := outputs[rel]; ok || skipCleanFilenames[rel] {
+			return nil
+		}
+
+		log.Printf("removing orphan: %s", path)
+		return os.Remove(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Remove any now-empty directories, deepest first.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entries, err := os.ReadDir(dirs[i])
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			if err := os.Remove(dirs[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// templates is safe for concurrent use by multiple goroutines once
+// loadTemplates returns: nothing modifies it afterwards, and render only
+// executes per-call clones of the layouts, never the layouts themselves.
+type templates struct {
+	// layouts contains parsed layout templates, keyed by their name
+	// (without file extensions). These must never be executed directly,
+	// since html/template doesn't allow cloning a template that has
+	// already been executed.
+	layouts map[string]*template.Template
+
+	// paths maps the name of each layout and partial to the file that it
+	// was loaded from; it's used to attribute template errors.
+	paths map[string]string
+
+	// funcs is the set of additional functions that we make available to
+	// templates. It is read-only after loadTemplates returns.
 	funcs template.FuncMap
+
+	// root is the name of the template that's executed to render a
+	// layout; see rootTemplate.
+	root string
+
+	// debug is whether partials and the content block are wrapped in
+	// HTML comments naming them; see -debug-comments.
+	debug bool
 }
 
-func loadTemplates(root string) (*templates, error) {
+func loadTemplates(fsys fs.FS, sharedDir, partialPrefix string, funcs template.FuncMap, root string, debugComments bool) (*templates, error) {
 	// Parse each template in the 'layouts' subdirectory of the given
-	// directory.
-	layoutDir, err := os.ReadDir(filepath.Join(root, "layouts"))
+	// filesystem.
+	layoutDir, err := fs.ReadDir(fsys, "layouts")
 	if err != nil {
 		return nil, err
 	}
 
 	// If there are any "partials"–i.e. template fragments that can be used
-	// in a layout–load them.
-	var partials map[string]string
-	if pdir, err := os.Open(filepath.Join(root, "partials")); err == nil {
-		defer pdir.Close()
-		entries, err := pdir.Readdirnames(-1)
+	// in a layout–load them. Partials may be organized into
+	// subdirectories, in which case the partial's name includes its path
+	// (e.g. "partials/cards/post.html" is named "_cards/post"). The "_"
+	// prefix can be changed with partialPrefix.
+	//
+	// Partials in sharedDir are loaded first, so that a local partial
+	// with the same name overrides the shared one; shared partials
+	// override built-in ones.
+	partials := make(map[string]string)
+	paths := make(map[string]string)
+	shared := make(map[string]bool)
+	if sharedDir != "" {
+		err := walkPartials(os.DirFS(sharedDir), ".", partialPrefix, func(name, file string, data []byte) error {
+			source := filepath.Join(sharedDir, filepath.FromSlash(file))
+			if other, ok := paths[name]; ok {
+				return fmt.Errorf("partial %q is defined by both %s and %s", name, other, source)
+			}
+			partials[name] = string(data)
+			paths[name] = source
+			shared[name] = true
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("loading shared partials: %w", err)
+		}
+	}
+	if st, err := fs.Stat(fsys, "partials"); err == nil && st.IsDir() {
+		err := walkPartials(fsys, "partials", partialPrefix, func(name, file string, data []byte) error {
+			source := templateSource(fsys, file)
+			if other, ok := paths[name]; ok {
+				if !shared[name] {
+					return fmt.Errorf("partial %q is defined by both %s and %s", name, other, source)
+				}
+				// Shared partials override the built-in ones.
+				if t, ok := fsys.(*templateFS); ok && t.builtin(file) {
+					return nil
+				}
+				log.Printf("partial %q from %s overrides shared partial %s", name, source, other)
+				delete(shared, name)
+			}
+			partials[name] = string(data)
+			paths[name] = source
+			return nil
+		})
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		partials = make(map[string]string, len(entries))
-		for _, entry := range entries {
-			data, err := os.ReadFile(filepath.Join(root, "partials", entry))
+	// Files in the layouts directory with a leading underscore, or in the
+	// 'macros' subdirectory, aren't layouts themselves; they contain
+	// shared define blocks that are added to every layout.
+	macros := make(map[string]string)
+	addMacro := func(name, file string) error {
+		if other, ok := paths[name]; ok {
+			return fmt.Errorf("macro %q is defined by both %s and %s", name, other, templateSource(fsys, file))
+		}
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return err
+		}
+		macros[name] = string(data)
+		paths[name] = templateSource(fsys, file)
+		return nil
+	}
+	var layoutEntries []fs.DirEntry
+	for _, entry := range layoutDir {
+		switch {
+		case entry.IsDir() && entry.Name() == "macros":
+			macroDir := "layouts/macros"
+			entries, err := fs.ReadDir(fsys, macroDir)
 			if err != nil {
 				return nil, err
 			}
-
-			// Remove any file extension from the partial name, and
-			// ensure it has a "_" prefix.
-			partialName, _, _ := strings.Cut(entry, ".")
-			if !strings.HasPrefix(partialName, "_") {
-				partialName = "_" + partialName
+			for _, me := range entries {
+				if me.IsDir() {
+					continue
+				}
+				base, _, _ := strings.Cut(me.Name(), ".")
+				if err := addMacro("macros/"+base, path.Join(macroDir, me.Name())); err != nil {
+					return nil, err
+				}
 			}
-			partials[partialName] = string(data)
+		case strings.HasPrefix(entry.Name(), "_"):
+			base, _, _ := strings.Cut(entry.Name(), ".")
+			if err := addMacro(base, path.Join("layouts", entry.Name())); err != nil {
+				return nil, err
+			}
+		default:
+			layoutEntries = append(layoutEntries, entry)
 		}
 	}
 
 	ret := &templates{
-		layouts: make(map[string]*template.Template, len(layoutDir)),
-		funcs:   template.FuncMap{},
+		layouts: make(map[string]*template.Template, len(layoutEntries)),
+		paths:   paths,
+		funcs:   funcs,
+		root:    root,
+		debug:   debugComments,
 	}
 
-	for _, entry := range layoutDir {
+	for _, entry := range layoutEntries {
 		layoutName, _, _ := strings.Cut(entry.Name(), ".")
 
 		// Read the file so that we can parse it with a specific name.
-		data, err := os.ReadFile(filepath.Join(root, "layouts", entry.Name()))
+		layoutPath := path.Join("layouts", entry.Name())
+		data, err := fs.ReadFile(fsys, layoutPath)
 		if err != nil {
 			return nil, err
 		}
+		paths[layoutName] = templateSource(fsys, layoutPath)
 
 		tmpl, err := template.New(layoutName).
 			Funcs(ret.funcs).
 			Parse(string(data))
 		if err != nil {
-			return nil, err
+			return nil, ret.locateTemplateError(err)
 		}
 
-		// Add any partials by name.
+		// Add any partials and macros by name. A partial mustn't replace
+		// one of the layout's own templates, which is easy to do by
+		// accident with an empty partialPrefix.
 		for name, content := range partials {
+			if tmpl.Lookup(name) != nil || name == "content" || name == "title" {
+				return nil, fmt.Errorf("partial %q from %s has the same name as a block in layout %q", name, paths[name], layoutName)
+			}
+			if debugComments {
+				content = debugWrap("partial:"+name, content)
+			}
 			if _, err := tmpl.New(name).Parse(content); err != nil {
-				return nil, err
+				return nil, ret.locateTemplateError(err)
+			}
+		}
+		for name, content := range macros {
+			if _, err := tmpl.New(name).Parse(content); err != nil {
+				return nil, ret.locateTemplateError(err)
 			}
 		}
 
@@ -309,6 +1369,66 @@ func loadTemplates(root string) (*templates, error) {
 	return ret, nil
 }
 
+// debugCommentFunc is the name of the template function that writes the
+// comments added by -debug-comments.
+const debugCommentFunc = "rpDebugComment"
+
+// debugWrap wraps the template text content in calls to debugCommentFunc
+// that mark the beginning and end of its output, e.g.
+// "<!-- begin partial:_header -->". The calls are added on the same lines as
+// the first and last lines of content, so that error positions don't
+// change.
+func debugWrap(label, content string) string {
+	return fmt.Sprintf(`{{ %s %q }}%s{{ %s %q }}`, debugCommentFunc, "begin "+label, content, debugCommentFunc, "end "+label)
+}
+
+// debugComment returns an HTML comment containing s.
+func debugComment(s string) template.HTML {
+	return template.HTML("<!-- " + strings.ReplaceAll(s, "--", "- -") + " -->")
+}
+
+// walkPartials calls fn with the name, path and contents of each partial
+// under dir in fsys. Partials are named after their path relative to dir,
+// without any extension, and with prefix added unless it already starts with
+// "_".
+func walkPartials(fsys fs.FS, dir, prefix string, fn func(name, file string, data []byte) error) error {
+	return fs.WalkDir(fsys, dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+
+		// Remove any file extension from the partial name, and add the
+		// prefix.
+		rel := name
+		if dir != "." {
+			rel = strings.TrimPrefix(name, dir+"/")
+		}
+		pdir, file := path.Split(rel)
+		base, _, _ := strings.Cut(file, ".")
+		partialName := pdir + base
+		if !strings.HasPrefix(partialName, "_") {
+			partialName = prefix + partialName
+		}
+		return fn(partialName, name, data)
+	})
+}
+
+// templateSource describes the file name in fsys for use in error messages.
+func templateSource(fsys fs.FS, name string) string {
+	if t, ok := fsys.(interface{ sourcePath(string) string }); ok {
+		return t.sourcePath(name)
+	}
+	return name
+}
+
 type renderData struct {
 	// Title is the title of the rendered page, as displayed in the page's
 	// <title> element.
@@ -316,13 +1436,73 @@ type renderData struct {
 	// Content is the main body content for the layout.
 	Content any
 	// Path is the relative path to the file being rendered, under the
-	// output directory.
+	// output directory. If -link-extensions=false, the '.html' extension
+	// is omitted.
 	Path string
+	// Charset is the name of the character encoding of the rendered page.
+	Charset string
+	// TOC is the page's table of contents, as a nested list of links to
+	// its headings.
+	TOC template.HTML
+	// Date is the page's date, from the 'date' frontmatter key, or the
+	// zero time if none was given.
+	Date time.Time
+	// ExtraCSS and ExtraJS are the URLs of additional stylesheets and
+	// scripts that this page needs, from the 'css' and 'js' frontmatter
+	// keys, as relURL would return them.
+	ExtraCSS []string
+	ExtraJS  []string
+	// Page is the listing information for this page, including its
+	// merged frontmatter as .Page.Params.
+	Page *pageRef
+	// Site is information about the whole site, including a list of
+	// all pages.
+	Site *siteData
+	// Format is the name of the output format being rendered, e.g.
+	// "html"; see the 'outputs' frontmatter key.
+	Format string
+	// Canonical is the absolute URL of the page, for <link
+	// rel="canonical">, or empty if there's no base URL.
+	Canonical string
+	// Breadcrumbs is the trail of sections from the home page to this
+	// page, which is the last crumb.
+	Breadcrumbs []breadcrumb
+	// Authors are the page's authors, from its 'author' or 'authors'
+	// frontmatter, for its byline.
+	Authors []*author
+	// Author is the author that an author page is about; it's nil for
+	// every other page.
+	Author *author
+	// Tasks are the page's task list items, with how many are done, or
+	// nil if it has none.
+	Tasks *taskList
+	// Variants maps the name of each of the page's output formats and
+	// variants, including "html", to its URL, e.g. for linking to its
+	// printable version.
+	Variants map[string]pageURL
+	// Excerpt is the page's summary: its 'summary' frontmatter, rendered
+	// as markdown; or else the page up to a <!--more--> line; or else the
+	// start of its text.
+	Excerpt template.HTML
+	// IsPost is whether the page has a date, like a blog post; IsDraft is
+	// whether it has 'draft: true' (and so is only built with -drafts);
+	// and HasTOC is whether TOC is non-empty.
+	IsPost  bool
+	IsDraft bool
+	HasTOC  bool
+	// Section is the top-level directory of the page's output, e.g.
+	// "docs" for docs/install/linux.html, or empty for pages at the root.
+	Section string
 
-	// TODO: maybe 'Data any'?
+	// urls is the URL configuration for this page's absURL and relURL
+	// calls.
+	urls urlConfig
+	// funcs are the functions from the func sets that the page opted
+	// into; see funcSets.
+	funcs template.FuncMap
 }
 
-func (t *templates) render(layout string, w io.Writer, data renderData) error {
+func (t *templates) render(layout string, w io.Writer, data renderData, enc *charsetEncoding) error {
 	tmpl, ok := t.layouts[layout]
 	if !ok {
 		return fmt.Errorf("layout %q not found", layout)
@@ -331,15 +1511,39 @@ func (t *templates) render(layout string, w io.Writer, data renderData) error {
 	// Create a new Template instance that includes our "overlay", which
 	// defines all the blocks that are required for the layout template to
 	// be rendered.
+	// Pages without any content of their own, such as author pages, use
+	// the layout's own "content" block instead.
 	var overlay strings.Builder
-	fmt.Fprintln(&overlay, `{{define "content"}}{{ .Content }}{{end}}`)
+	if data.Content != nil {
+		content := `{{ .Content }}`
+		if t.debug {
+			content = debugWrap("block:content", content)
+		}
+		fmt.Fprintln(&overlay, `{{define "content"}}`+content+`{{end}}`)
+	}
 
 	// If we have a non-empty Title attribute, override that block as well.
 	if data.Title != "" {
 		fmt.Fprintln(&overlay, `{{define "title"}}{{ .Title }}{{end}}`)
 	}
 
-	overlayTmpl, err := template.Must(tmpl.Clone()).Parse(overlay.String())
+	// Clone the layout so that parsing the overlay doesn't modify the
+	// shared template; each call gets its own isolated copy.
+	cloned, err := tmpl.Clone()
+	if err != nil {
+		return fmt.Errorf("cloning layout %q: %w", layout, err)
+	}
+	cloned.Funcs(data.urls.funcs())
+	cloned.Funcs(partialFuncs(cloned))
+	if data.funcs != nil {
+		cloned.Funcs(data.funcs)
+	}
+	overlayTmpl, err := cloned.Parse(overlay.String())
+	if err != nil {
+		return err
+	}
+
+	root, err := t.rootTemplate(overlayTmpl, layout)
 	if err != nil {
 		return err
 	}
@@ -347,67 +1551,930 @@ func (t *templates) render(layout string, w io.Writer, data renderData) error {
 	// Render to a buffer and then to the output file to ensure that we
 	// don't write a half-valid file.
 	var outBuf bytes.Buffer
-	if err := overlayTmpl.ExecuteTemplate(&outBuf, "base", data); err != nil {
-		return err
+	if err := executeTemplate(overlayTmpl, &outBuf, root, data); err != nil {
+		return t.locateTemplateError(err)
 	}
 
-	if _, err := outBuf.WriteTo(w); err != nil {
+	if _, err := w.Write(enc.transcode(outBuf.Bytes())); err != nil {
 		return err
 	}
 	return nil
 }
 
+// rootTemplate returns the name of the template to execute to render the
+// layout tmpl: the template named by -base-template if the layout defines
+// it, and otherwise the layout's own top-level content.
+func (t *templates) rootTemplate(tmpl *template.Template, layout string) (string, error) {
+	if r := tmpl.Lookup(t.root); r != nil && r.Tree != nil {
+		return t.root, nil
+	}
+	if tmpl.Tree != nil && strings.TrimSpace(tmpl.Tree.Root.String()) != "" {
+		return tmpl.Name(), nil
+	}
+	return "", fmt.Errorf("layout %q doesn't define a %q template, and has no top-level content to render instead", layout, t.root)
+}
+
+// addAliases makes each key of aliases another name for the layout that it
+// maps to, which must exist.
+func (t *templates) addAliases(aliases map[string]string) error {
+	for _, alias := range slices.Sorted(maps.Keys(aliases)) {
+		target := aliases[alias]
+		if _, ok := t.layouts[alias]; ok {
+			return fmt.Errorf("layout alias %q has the same name as the layout from %s", alias, t.paths[alias])
+		}
+		if _, ok := aliases[target]; ok {
+			return fmt.Errorf("layout alias %q points to another alias, %q", alias, target)
+		}
+		if _, ok := t.layouts[target]; !ok {
+			return fmt.Errorf("layout alias %q points to layout %q, which doesn't exist", alias, target)
+		}
+	}
+	for alias, target := range aliases {
+		t.layouts[alias] = t.layouts[target]
+		t.paths[alias] = t.paths[target]
+	}
+	return nil
+}
+
+// validate renders every layout with empty data, discarding the output, to
+// surface errors such as references to missing partials or blocks.
+func (t *templates) validate() []error {
+	names := make([]string, 0, len(t.layouts))
+	for name := range t.layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	for _, name := range names {
+		if err := t.render(name, io.Discard, renderData{}, charsetUTF8); err != nil {
+			errs = append(errs, attributeError(t.paths[name], fmt.Errorf("layout %q: %w", name, err)))
+		}
+	}
+	return errs
+}
+
+// executeTemplate executes the named template, converting any panic that
+// occurs during execution (e.g. from a method called on a nil value) into an
+// error, so that a single bad page doesn't crash the whole build.
+func executeTemplate(tmpl *template.Template, w io.Writer, name string, data renderData) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while executing layout %q for %s: %v", tmpl.Name(), data.Path, r)
+		}
+	}()
+	return tmpl.ExecuteTemplate(w, name, data)
+}
+
+// publishOptions determine which pages are built; see unpublished.
+type publishOptions struct {
+	// now is the time that page dates are compared to.
+	now time.Time
+	// drafts, future and expired are whether to build drafts, pages
+	// dated in the future, and expired pages.
+	drafts, future, expired bool
+}
+
 type mdGenerator struct {
-	md    goldmark.Markdown
+	// md converts pages with the default extensions, and markdowns
+	// creates the converters for pages that choose their own.
+	md        goldmark.Markdown
+	markdowns *markdownSet
+
 	tmpls *templates
 	pol   *bluemonday.Policy
+	cfg   *siteConfig
+	out   outputWriter
+
+	// linkExt is whether links to pages include the '.html' extension.
+	linkExt bool
+
+	// charset is the default encoding of generated pages.
+	charset *charsetEncoding
+
+	// strict is whether warnings should be treated as errors.
+	strict bool
+
+	// staticDir is the directory containing static files, if any.
+	staticDir string
+
+	// tocDepth is the maximum heading level included in tables of
+	// contents.
+	tocDepth int
+
+	// sourceDir is the root of the source directory; it's used to
+	// compute the source-relative path of each page.
+	sourceDir string
+
+	// cascades holds the 'cascade' frontmatter of each section index,
+	// keyed by directory; see loadCascades.
+	cascades map[string]map[string]any
+
+	// urls is the site-wide URL configuration; pages can override its
+	// base URL.
+	urls urlConfig
+
+	// schema, if non-nil, declares the frontmatter that pages may have.
+	schema frontmatterSchema
+
+	// diagrams, if non-nil, renders diagrams and math at build time.
+	diagrams *diagramRenderer
+
+	// titleFromHeading is whether a page without a title uses its first
+	// level 1 heading instead, and requireTitle is whether it's an error
+	// for a page to have no title at all.
+	titleFromHeading bool
+	requireTitle     bool
+
+	// images, if non-nil, inlines small images into pages.
+	images *imageInliner
+
+	// metaFileWins is whether a page's metadata file takes precedence
+	// over its frontmatter; see -metadata-precedence.
+	metaFileWins bool
+
+	// noFrontmatter is the -no-frontmatter mode for pages without
+	// frontmatter: "ignore", "derive" or "error".
+	noFrontmatter string
+
+	// verbose is whether to log the details of how pages are built; see
+	// -verbose.
+	verbose bool
+
+	// preserveTimes is whether rendered pages get the modification time
+	// of their markdown file.
+	preserveTimes bool
+
+	// profile, if non-nil, times each phase of building each page.
+	profile *pageProfiler
+
+	// filter, if non-nil, post-processes rendered HTML pages.
+	filter *outputFilter
+
+	// checkHTML is whether rendered HTML pages are checked for
+	// mismatched tags; see htmlProblems.
+	checkHTML bool
+
+	// publish determines which pages are published.
+	publish publishOptions
+
+	// defaultLayout, if non-empty, is used instead of a layout that
+	// doesn't exist.
+	defaultLayout string
+
+	// authorInfo is the contents of the -authors file, and authors are
+	// the authors of the pages converted so far, keyed by name. If
+	// authorPages is set (-author-pages), a page is generated for each
+	// author.
+	authorInfo  map[string]authorInfo
+	authors     map[string]*author
+	authorPages bool
+
+	// cache, if non-nil, holds the results of converting markdown in
+	// previous builds.
+	cache *buildCache
+
+	// csp, if non-nil, moves inline styles out of rendered pages and
+	// collects the hashes of their inline scripts.
+	csp *cspCollector
+
+	// aliases are collected from each converted page, and written once
+	// all pages have been generated.
+	aliases []pageAlias
 }
 
-func (g *mdGenerator) convertMarkdownFile(outDir, relPath, src string) error {
+// convertMarkdownFile converts the markdown file src to HTML and loads its
+// metadata, returning a page that's ready to be rendered to relPath. It
+// returns a nil page if the page isn't published; see unpublished.
+func (g *mdGenerator) convertMarkdownFile(relPath, src string) (*page, error) {
 	// Read the markdown file
 	b, err := os.ReadFile(src)
 	if err != nil {
-		return err
+		return nil, &buildError{File: src, Err: err}
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, &buildError{File: src, Err: err}
 	}
 
-	outPath := filepath.Join(outDir, relPath)
-	df, err := os.Create(outPath)
+	relSrc, err := filepath.Rel(g.sourceDir, src)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	fileMeta, err := loadMetadataFile(src)
+	if err != nil {
+		return nil, &buildError{File: src, Err: err}
 	}
-	defer df.Close()
+	return g.convertPage(b, fi.ModTime(), relPath, src, relSrc, fileMeta)
+}
 
-	// Parse the markdown file
-	var buf bytes.Buffer
-	context := parser.NewContext()
-	if err := g.md.Convert(b, &buf, parser.WithContext(context)); err != nil {
-		return err
+// convertPage converts the markdown b, read from src (at relSrc relative to
+// the source directory) and last modified at modTime, to the page that's
+// rendered to relPath. fileMeta is the contents of the page's metadata file,
+// or nil if it has none. It returns nil if the page is skipped because it
+// isn't published.
+func (g *mdGenerator) convertPage(b []byte, modTime time.Time, relPath, src, relSrc string, fileMeta map[string]any) (*page, error) {
+	conv, ok := g.cache.get(relSrc, b)
+	var err error
+	if ok {
+		for _, msg := range conv.warnings {
+			if err := g.warn(&buildError{File: src, Err: errors.New(msg)}); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		conv, err = g.convertMarkdown(b, src, relSrc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sanitized, toc, frontmatter := conv.content, conv.toc, conv.frontmatter
+	// Images are inlined after the conversion is cached, so that changes
+	// to them are picked up.
+	if g.images != nil {
+		sanitized = g.images.inline(sanitized, relSrc)
+	}
+	if fileMeta != nil {
+		frontmatter = g.withMetadataFile(frontmatter, fileMeta)
+	}
+	plain := frontmatter == nil && g.noFrontmatter == "derive"
+	if frontmatter == nil && g.noFrontmatter == "error" {
+		return nil, &buildError{File: src, Err: errors.New("page has no frontmatter")}
 	}
-	metaData := meta.Get(context)
 
-	// Sanitize the generated HTML.
-	sanitized := template.HTML(g.pol.Sanitize(buf.String()))
+	// Apply the page's build directives. sanitize was already applied
+	// when the markdown was converted.
+	directives, unknown, err := parseDirectives(frontmatter)
+	if err != nil {
+		return nil, &buildError{File: src, Err: err}
+	}
+	for _, key := range unknown {
+		if err := g.warn(&buildError{File: src, Err: fmt.Errorf("unknown build directive '%s.%s'", directivesKey, key)}); err != nil {
+			return nil, err
+		}
+	}
+	if directives.extension != "" {
+		relPath = strings.TrimSuffix(relPath, filepath.Ext(relPath)) + "." + directives.extension
+	}
+
+	// Merge any configured defaults and cascaded values from section
+	// indexes under the page's own frontmatter.
+	metaData := g.cfg.defaultsFor(relSrc)
+	if metaData == nil {
+		metaData = make(map[string]any)
+	}
+	mergeMeta(metaData, g.cascadeFor(relSrc))
+	if plain {
+		mergeMeta(metaData, g.cfg.PlainDefaults)
+	}
+	var schemaErrs []error
+	for _, err := range g.schema.validate(relSrc, frontmatter) {
+		if err := g.warn(&buildError{File: src, Err: err}); err != nil {
+			schemaErrs = append(schemaErrs, err)
+		}
+	}
+	if len(schemaErrs) > 0 {
+		return nil, errors.Join(schemaErrs...)
+	}
+	mergeMeta(metaData, frontmatter)
 
 	// Get the layout from the frontmatter.
-	layout := "base"
-	if t, ok := metaData["layout"].(string); ok {
-		layout = t
+	layout, err := g.metaString(metaData, "layout", src)
+	if err != nil {
+		return nil, err
+	}
+	if layout == "" {
+		layout = "base"
+	}
+	if _, ok := g.tmpls.layouts[layout]; !ok && g.defaultLayout != "" {
+		if err := g.warn(&buildError{
+			File: src,
+			Err:  fmt.Errorf("layout %q not found; using %q instead", layout, g.defaultLayout),
+		}); err != nil {
+			return nil, err
+		}
+		layout = g.defaultLayout
+	}
+
+	// Determine which formats the page is rendered in.
+	formats, err := g.metaStringList(metaData, "outputs", src)
+	if err != nil {
+		return nil, err
+	}
+	for _, format := range formats {
+		if format == "" || strings.ContainsAny(format, `/\.`) {
+			return nil, &buildError{File: src, Err: fmt.Errorf("invalid output format %q", format)}
+		}
+	}
+	outputs := pageOutputs(formats, layout, relPath)
+	variants, err := g.cfg.pageVariants(relSrc, relPath)
+	if err != nil {
+		return nil, &buildError{File: src, Err: err}
+	}
+	for _, v := range variants {
+		if slices.Contains(formats, v.format) {
+			return nil, &buildError{File: src, Err: fmt.Errorf("output format %q is also a variant", v.format)}
+		}
+	}
+	outputs = append(outputs, variants...)
+
+	// Load the title (if given), falling back to the first heading.
+	title, err := g.metaString(metaData, "title", src)
+	if err != nil {
+		return nil, err
+	}
+	if title == "" && g.titleFromHeading {
+		title = conv.heading
+	}
+	if title == "" && plain {
+		title = plainTitle(conv.heading, relSrc)
+		if g.verbose {
+			log.Printf("%s has no frontmatter; using the title %q", src, title)
+		}
+	}
+	if title == "" && g.requireTitle {
+		return nil, &buildError{File: src, Err: errors.New("page has no title")}
+	}
+
+	// Parse the page's date, if any, and leave out drafts, and pages that
+	// aren't published yet or have expired.
+	date, reason, err := g.unpublished(metaData, src)
+	if err != nil {
+		return nil, err
+	} else if reason != "" {
+		log.Printf("skipping %s: %s", src, reason)
+		return nil, nil
+	}
+	draft, _ := metaData["draft"].(bool)
+
+	// Record any aliases for this page.
+	aliases, err := parseAliases(metaData, src, "/"+g.linkPath(relPath))
+	if err != nil {
+		return nil, &buildError{File: src, Err: err}
+	}
+	g.aliases = append(g.aliases, aliases...)
+
+	// Load the page's weight, which determines its position in listings.
+	weight, err := g.metaInt(metaData, "weight", src)
+	if err != nil {
+		return nil, err
+	}
+	_, hasWeight := metaData["weight"]
+
+	// Determine the output encoding.
+	enc := g.charset
+	if c, err := g.metaString(metaData, "charset", src); err != nil {
+		return nil, err
+	} else if c != "" {
+		enc, err = lookupCharset(c)
+		if err != nil {
+			return nil, &buildError{File: src, Err: err}
+		}
+	}
+
+	// Load any func sets that the page opted into.
+	sets, err := g.metaStringList(metaData, "funcs", src)
+	if err != nil {
+		return nil, err
+	}
+	funcs, err := pageFuncs(sets)
+	if err != nil {
+		return nil, &buildError{File: src, Err: err}
+	}
+
+	// Pages can have their own base URL, e.g. for cross-posts whose
+	// canonical version is on another site.
+	urls := g.urls
+	if v, err := g.metaString(metaData, "baseURL", src); err != nil {
+		return nil, err
+	} else if v != "" {
+		if err := checkBaseURL(v); err != nil {
+			return nil, &buildError{File: src, Err: fmt.Errorf("invalid baseURL: %w", err)}
+		}
+		urls.baseURL = v
+	}
+
+	// Load any extra stylesheets and scripts for this page.
+	extraCSS, err := g.pageAssets(metaData, "css", urls, src)
+	if err != nil {
+		return nil, err
+	}
+	extraJS, err := g.pageAssets(metaData, "js", urls, src)
+	if err != nil {
+		return nil, err
+	}
+
+	authors, err := g.pageAuthors(metaData, src)
+	if err != nil {
+		return nil, err
+	}
+
+	// The page's excerpt is its summary, if it has one, or else the
+	// part of it before any <!--more--> line.
+	excerpt := conv.excerpt
+	if g.images != nil && excerpt != "" {
+		excerpt = string(g.images.inline([]byte(excerpt), relSrc))
+	}
+	if summary, err := g.metaString(metaData, "summary", src); err != nil {
+		return nil, err
+	} else if summary != "" {
+		if excerpt, err = g.markdownify(summary); err != nil {
+			return nil, &buildError{File: src, Err: fmt.Errorf("error rendering summary: %w", err)}
+		}
 	}
 
-	// Load the title (if given)
-	var title string
-	if t, ok := metaData["title"].(string); ok {
-		title = t
+	variantURLs := make(map[string]pageURL, len(outputs))
+	for _, o := range outputs {
+		variantURLs[o.format] = pageURL("/" + g.linkPath(o.relPath))
 	}
 
-	// Render the markdown file using the template
-	if err := g.tmpls.render(layout, df, renderData{
-		Title:   title,
-		Content: sanitized,
-		Path:    relPath,
-	}); err != nil {
+	ref := &pageRef{
+		Title:     title,
+		URL:       pageURL("/" + g.linkPath(outputs[0].relPath)),
+		Date:      date,
+		Weight:    weight,
+		Params:    metaData,
+		Authors:   authors,
+		Tasks:     newTaskList(conv.tasks),
+		hasWeight: hasWeight,
+	}
+	ref.WordCount = wordCount(string(sanitized))
+	ref.ReadingTime = readingTime(ref.WordCount)
+	if urls.baseURL != "" {
+		ref.Permalink = urls.absPageURL(ref.URL)
+	}
+	p := &page{
+		src:     src,
+		relPath: relPath,
+		render:  true,
+		layout:  layout,
+		enc:     enc,
+		ref:     ref,
+		outputs: outputs,
+		modTime: modTime,
+		anchors: conv.anchors,
+		data: renderData{
+			Title:     title,
+			Content:   template.HTML(sanitized),
+			Path:      g.linkPath(relPath),
+			Charset:   enc.name,
+			TOC:       template.HTML(toc),
+			Date:      date,
+			ExtraCSS:  extraCSS,
+			ExtraJS:   extraJS,
+			Page:      ref,
+			Canonical: ref.Permalink,
+			Authors:   authors,
+			IsPost:    !date.IsZero(),
+			IsDraft:   draft,
+			HasTOC:    toc != "",
+			Section:   pageSection(relPath),
+			Excerpt:   template.HTML(excerpt),
+			Variants:  variantURLs,
+			Tasks:     ref.Tasks,
+			urls:      urls,
+			funcs:     funcs,
+		},
+	}
+	if excerpt == "" {
+		p.data.Excerpt = truncatedExcerpt(string(sanitized))
+	}
+	p.data.Breadcrumbs = g.breadcrumbs(relPath, title)
+	return p, nil
+}
+
+// pageSection returns the top-level directory of the output path relPath,
+// or "" if it's at the root.
+func pageSection(relPath string) string {
+	dir, _, ok := strings.Cut(filepath.ToSlash(relPath), "/")
+	if !ok {
+		return ""
+	}
+	return dir
+}
+
+// plainTitle returns the title of a page without frontmatter: its first
+// level 1 heading, if any, or else a title made from its file name (or its
+// directory's name, for a section index).
+func plainTitle(heading, relSrc string) string {
+	if heading != "" {
+		return heading
+	}
+	name := filepath.Base(relSrc)
+	if name == sectionIndexName {
+		if filepath.Dir(relSrc) == "." {
+			return "Home"
+		}
+		name = filepath.Base(filepath.Dir(relSrc))
+	}
+	return titleize(strings.TrimSuffix(name, filepath.Ext(name)))
+}
+
+// conversion is the result of converting a markdown file.
+type conversion struct {
+	// content is the sanitized HTML.
+	content []byte
+	// toc is the table of contents.
+	toc string
+	// heading is the text of the first level 1 heading, if any.
+	heading string
+	// anchors are the IDs of the page's headings.
+	anchors []string
+	// excerpt is the sanitized HTML of the page up to its moreMarker, or
+	// empty if it has none.
+	excerpt string
+	// tasks are the page's task list items.
+	tasks []taskItem
+	// frontmatter is the page's own frontmatter, or nil if it has none.
+	frontmatter map[string]any
+	// warnings are the messages of the warnings raised while converting
+	// the page, which are reported again when it's reused from the cache.
+	warnings []string
+}
+
+// renderAndSanitize renders the parsed markdown doc, from source, to HTML
+// and sanitizes it. The rendered HTML is normally streamed straight into the
+// sanitizer; with -profile-pages, it's buffered in between, so that each can
+// be timed.
+func (g *mdGenerator) renderAndSanitize(md goldmark.Markdown, pol sanitizer, source []byte, doc ast.Node, src string) (*bytes.Buffer, error) {
+	if g.profile != nil {
+		var rendered bytes.Buffer
+		endPhase := g.profile.time(src, phaseMarkdown)
+		err := md.Renderer().Render(&rendered, source, doc)
+		endPhase()
+		if err != nil {
+			return nil, err
+		}
+		defer g.profile.time(src, phaseSanitize)()
+		return pol.SanitizeReader(&rendered), nil
+	}
+
+	pr, pw := io.Pipe()
+	renderErr := make(chan error, 1)
+	go func() {
+		err := md.Renderer().Render(pw, source, doc)
+		pw.CloseWithError(err)
+		renderErr <- err
+	}()
+	sanitized := pol.SanitizeReader(pr)
+	pr.Close() // in case the sanitizer stopped early
+	return sanitized, <-renderErr
+}
+
+// convertMarkdown converts the markdown in b, read from src, to sanitized
+// HTML, and returns it with its table of contents and frontmatter. The result
+// is stored in the build cache, unless the page had a problem that should be
+// reported again on the next build.
+func (g *mdGenerator) convertMarkdown(b []byte, src, relSrc string) (*conversion, error) {
+	// Parse the markdown file.
+	context := parser.NewContext()
+	context.Set(pageDirKey, path.Dir(filepath.ToSlash(relSrc)))
+	endPhase := g.profile.time(src, phaseParse)
+	doc := g.md.Parser().Parse(text.NewReader(b), parser.WithContext(context))
+	endPhase()
+
+	// The frontmatter is parsed along with the markdown, so the page can
+	// choose its extensions, or opt out of sanitization, before it's
+	// rendered. A page with its own extensions, or that isn't sanitized
+	// and so keeps its raw HTML, is parsed again with them.
+	//
+	// Warnings are recorded in the conversion, so that they're reported
+	// again when it's reused from the build cache.
+	frontmatter, fmErr := meta.TryGet(context)
+	directives, _, err := parseDirectives(frontmatter)
+	if err != nil {
+		return nil, &buildError{File: src, Err: err}
+	}
+	md := g.md
+	var warnings []string
+	names, err := stringList(frontmatter, "extensions")
+	if err != nil {
+		warnings = append(warnings, err.Error())
+		if err := g.warn(&buildError{File: src, Err: err}); err != nil {
+			return nil, err
+		}
+	}
+	if names != nil || !directives.sanitize {
+		if md, err = g.markdowns.get(names, !directives.sanitize); err != nil {
+			return nil, &buildError{File: src, Err: err}
+		}
+		if md != g.md {
+			context = parser.NewContext()
+			context.Set(pageDirKey, path.Dir(filepath.ToSlash(relSrc)))
+			endPhase := g.profile.time(src, phaseParse)
+			doc = md.Parser().Parse(text.NewReader(b), parser.WithContext(context))
+			endPhase()
+		}
+	}
+	var pol sanitizer = g.pol
+	if !directives.sanitize {
+		pol = noSanitizer{}
+	}
+
+	sanitizedBuf, err := g.renderAndSanitize(md, pol, b, doc, src)
+	if err != nil {
+		return nil, &buildError{File: src, Err: err}
+	}
+
+	// Build the table of contents, and insert it in place of any
+	// placeholders in the page. Then insert any diagrams that we
+	// rendered, which would otherwise have been mangled by the sanitizer.
+	conv := &conversion{warnings: warnings}
+	headings := collectHeadings(doc, b)
+	conv.toc = pol.Sanitize(tocHTML(headings, g.tocDepth))
+	conv.content = replaceTOCPlaceholders(sanitizedBuf.Bytes(), conv.toc)
+	if g.diagrams != nil {
+		conv.content = g.diagrams.expand(conv.content)
+	}
+	if i := slices.IndexFunc(headings, func(h tocHeading) bool { return h.level == 1 }); i >= 0 {
+		conv.heading = headings[i].text
+	}
+	for _, h := range headings {
+		if h.id != "" {
+			conv.anchors = append(conv.anchors, h.id)
+		}
+	}
+	conv.tasks = collectTasks(doc, b)
+
+	// Render the page again up to its moreMarker, if it has one, for its
+	// excerpt. This removes the rest of the page from doc.
+	if cutAtMore(doc, b) {
+		var buf bytes.Buffer
+		if err := md.Renderer().Render(&buf, b, doc); err != nil {
+			return nil, &buildError{File: src, Err: err}
+		}
+		excerpt := replaceTOCPlaceholders([]byte(pol.Sanitize(buf.String())), "")
+		if g.diagrams != nil {
+			excerpt = g.diagrams.expand(excerpt)
+		}
+		conv.excerpt = string(excerpt)
+	}
+
+	conv.frontmatter = frontmatter
+	if fmErr != nil {
+		if err := g.warn(frontmatterError(src, fmErr)); err != nil {
+			return nil, err
+		}
+		return conv, nil
+	}
+
+	// Don't cache pages with diagrams that were rendered client-side
+	// because their command failed, so that they're retried next time.
+	if _, clientSide := doc.AttributeString(clientSideDiagramsAttr); g.cache != nil && !clientSide {
+		if err := g.cache.put(relSrc, b, conv); err != nil {
+			log.Printf("warning: error writing build cache: %v", err)
+		}
+	}
+	return conv, nil
+}
+
+// unpublished returns the date of a page with the given frontmatter, from
+// its 'date' key, and why it shouldn't be built, or the empty string if it
+// should be:
+//
+//   - it has 'draft: true', and -drafts isn't set;
+//   - its date is after the build time, and -future isn't set;
+//   - its 'expiryDate' is at or before the build time, and -expired isn't
+//     set.
+//
+// Drafts are left out before their dates are parsed, so that an invalid
+// date in a draft that isn't being built doesn't fail the build.
+func (g *mdGenerator) unpublished(metaData map[string]any, src string) (date time.Time, reason string, err error) {
+	if v, ok := metaData["draft"]; ok {
+		draft, ok := v.(bool)
+		if !ok {
+			if err := g.warn(&buildError{
+				File: src,
+				Err:  fmt.Errorf("frontmatter key %q should be a bool, not %T", "draft", v),
+			}); err != nil {
+				return time.Time{}, "", err
+			}
+		}
+		if draft && !g.publish.drafts {
+			return time.Time{}, "it's a draft", nil
+		}
+	}
+	if v, ok := metaData["date"]; ok {
+		if date, err = g.cfg.parseDate(v); err != nil {
+			return time.Time{}, "", &buildError{File: src, Err: err}
+		}
+	}
+	if !date.IsZero() && date.After(g.publish.now) && !g.publish.future {
+		return date, fmt.Sprintf("its date %s is in the future", date.Format(time.RFC3339)), nil
+	}
+	if v, ok := metaData["expiryDate"]; ok {
+		expiry, err := g.cfg.parseDate(v)
+		if err != nil {
+			return date, "", &buildError{File: src, Err: fmt.Errorf("invalid expiryDate: %w", err)}
+		}
+		if !expiry.After(g.publish.now) && !g.publish.expired {
+			return date, fmt.Sprintf("it expired at %s", expiry.Format(time.RFC3339)), nil
+		}
+	}
+	return date, "", nil
+}
+
+// renderPage renders a converted page using its layout, and writes it to the
+// output.
+func (g *mdGenerator) renderPage(p *page, site *siteData) error {
+	defer g.profile.time(p.src, phaseTemplates)()
+	for _, o := range p.outputs {
+		data := p.data
+		data.Site = site
+		data.Path = g.linkPath(o.relPath)
+		data.Format = o.format
+
+		var modTime time.Time
+		if g.preserveTimes {
+			modTime = p.modTime
+		}
+		if err := g.writeOutput(o.renderer, o.layout, o.relPath, data, p.enc, modTime); err != nil {
+			return attributeError(p.src, err)
+		}
+	}
+	return nil
+}
+
+// writePage renders data with layout, and writes it to relPath in the
+// output with the modification time modTime, if it's non-zero.
+func (g *mdGenerator) writePage(layout, relPath string, data renderData, enc *charsetEncoding, modTime time.Time) error {
+	return g.writeOutput(templateRenderer{}, layout, relPath, data, enc, modTime)
+}
+
+// writeOutput renders data with r, and writes it to relPath in the output
+// with the modification time modTime, if it's non-zero.
+func (g *mdGenerator) writeOutput(r pageRenderer, layout, relPath string, data renderData, enc *charsetEncoding, modTime time.Time) error {
+	// Pages rendered with a layout are HTML, whatever their extension.
+	_, isHTML := r.(templateRenderer)
+
+	// The filter command is given the page as UTF-8, and the page is
+	// transcoded to its charset afterwards.
+	filter := g.filter != nil && isHTML
+	renderEnc := enc
+	if filter {
+		renderEnc = charsetUTF8
+	}
+	out, err := r.render(g, layout, data, renderEnc)
+	if err != nil {
 		return err
 	}
+	if filter {
+		if out, err = g.filter.run(out); err != nil {
+			return fmt.Errorf("error filtering %s: %w", relPath, err)
+		}
+		out = enc.transcode(out)
+	}
+	if g.checkHTML && isHTML {
+		if err := g.checkHTMLOutput(relPath, out); err != nil {
+			return err
+		}
+	}
+	return g.out.WriteFile(relPath, bytes.NewReader(out), 0, modTime)
+}
 
+// warn logs err as a warning or, in strict mode, returns it so that it fails
+// the build.
+func (g *mdGenerator) warn(err error) error {
+	if g.strict {
+		return err
+	}
+	log.Printf("warning: %v", err)
 	return nil
 }
+
+// metaString returns the string value of key in a page's frontmatter, or the
+// empty string if it's not present. A value of the wrong type is a warning.
+func (g *mdGenerator) metaString(metaData map[string]any, key, src string) (string, error) {
+	v, ok := metaData[key]
+	if !ok {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", g.warn(&buildError{
+			File: src,
+			Err:  fmt.Errorf("frontmatter key %q should be a string, not %T", key, v),
+		})
+	}
+	return s, nil
+}
+
+// linkPath returns the slash-separated path used when linking to the page
+// written at relPath, including any output prefix.
+func (g *mdGenerator) linkPath(relPath string) string {
+	p := g.outputLinkPath(relPath)
+	if g.urls.prefix != "" && p == "" {
+		// The root index, linked to by the prefix directory itself.
+		return strings.TrimPrefix(g.urls.fixSlash("/"+g.urls.prefix+"/"), "/")
+	}
+	return g.urls.prefixed(p)
+}
+
+// outputLinkPath returns the path used when linking to the page written at
+// relPath, relative to the root of the output (i.e. without any output
+// prefix).
+func (g *mdGenerator) outputLinkPath(relPath string) string {
+	p := filepath.ToSlash(relPath)
+	if g.urls.flatten {
+		p = flattenPath(p)
+	}
+	if g.linkExt {
+		return p
+	}
+	p = strings.TrimSuffix(p, ".html")
+	if g.urls.trailingSlash == "auto" {
+		return p
+	}
+
+	// Index pages are linked to by their directory.
+	if p == "index" || strings.HasSuffix(p, "/index") {
+		p = strings.TrimSuffix(p, "index")
+	}
+	if p == "" {
+		return p
+	}
+	return strings.TrimPrefix(g.urls.fixSlash("/"+p), "/")
+}
+
+// metaInt returns the integer value of key in a page's frontmatter, or zero
+// if it's not present. A value of the wrong type is a warning.
+func (g *mdGenerator) metaInt(metaData map[string]any, key, src string) (int, error) {
+	v, ok := metaData[key]
+	if !ok {
+		return 0, nil
+	}
+	n, ok := v.(int)
+	if !ok {
+		return 0, g.warn(&buildError{
+			File: src,
+			Err:  fmt.Errorf("frontmatter key %q should be an integer, not %T", key, v),
+		})
+	}
+	return n, nil
+}
+
+// metaStringList returns the value of key in a page's frontmatter as a list
+// of strings. A single string is treated as a list of one item. A value of
+// the wrong type is a warning.
+func (g *mdGenerator) metaStringList(metaData map[string]any, key, src string) ([]string, error) {
+	list, err := stringList(metaData, key)
+	if err != nil {
+		return nil, g.warn(&buildError{File: src, Err: err})
+	}
+	return list, nil
+}
+
+// stringList is like metaStringList, but returns a value of the wrong type
+// as an error rather than a warning.
+func stringList(metaData map[string]any, key string) ([]string, error) {
+	v, ok := metaData[key]
+	if !ok {
+		return nil, nil
+	}
+	if s, ok := v.(string); ok {
+		return []string{s}, nil
+	}
+	list, ok := v.([]any)
+	if ok {
+		ret := make([]string, 0, len(list))
+		for _, item := range list {
+			s, ok := item.(string)
+			if !ok {
+				break
+			}
+			ret = append(ret, s)
+		}
+		if len(ret) == len(list) {
+			return ret, nil
+		}
+	}
+	return nil, fmt.Errorf("frontmatter key %q should be a list of strings, not %T", key, v)
+}
+
+// pageAssets returns the URLs of the static files listed under key in a
+// page's frontmatter, as returned by relURL for the page's urls. Files that
+// don't exist in the static directory are a warning.
+func (g *mdGenerator) pageAssets(metaData map[string]any, key string, urls urlConfig, src string) ([]string, error) {
+	assets, err := g.metaStringList(metaData, key, src)
+	if err != nil || len(assets) == 0 {
+		return nil, err
+	}
+
+	ret := make([]string, len(assets))
+	for i, asset := range assets {
+		rel := strings.TrimPrefix(asset, "/")
+		ret[i] = urls.relURL("/" + rel)
+		if g.staticDir == "" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(g.staticDir, filepath.FromSlash(rel))); err != nil {
+			if err := g.warn(&buildError{
+				File: src,
+				Err:  fmt.Errorf("%s file %q not found in static directory %s", key, asset, g.staticDir),
+			}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return ret, nil
+}
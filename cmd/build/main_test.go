@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// buildPage builds a site whose only page is index.md, with the given
+// markdown, and returns the HTML of its content. flags are pairs of flag
+// names and values, which are reset when the test finishes.
+func buildPage(t testing.TB, markdown string, flags ...string) string {
+	t.Helper()
+	for i := 0; i+1 < len(flags); i += 2 {
+		setTestFlag(t, flags[i], flags[i+1])
+	}
+	src, out := newTestSite(t, map[string]string{"index.md": markdown})
+	if err := build(src, out); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(out, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mainContent(string(b))
+}
+
+// newTestSite writes files, keyed by their slash-separated paths, to a new
+// source directory, and returns it along with an empty output directory.
+// Log output is discarded until the test finishes.
+func newTestSite(t testing.TB, files map[string]string) (src, out string) {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	dir := t.TempDir()
+	src, out = filepath.Join(dir, "content"), filepath.Join(dir, "out")
+	for _, d := range []string{src, out} {
+		if err := os.Mkdir(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return src, out
+}
+
+// setTestFlag sets the named flag, and resets it to its default when the
+// test finishes.
+func setTestFlag(t testing.TB, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	if f == nil {
+		t.Fatalf("no flag -%s", name)
+	}
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("setting -%s: %v", name, err)
+	}
+	t.Cleanup(func() { flag.Set(name, f.DefValue) })
+}
+
+// mainContent returns the contents of the <main> element in a page rendered
+// with the built-in layout.
+func mainContent(page string) string {
+	_, s, _ := strings.Cut(page, `<main class="content" id="content">`)
+	s, _, _ = strings.Cut(s, "</main>")
+	return strings.TrimSpace(s)
+}
+
+// newTestTemplates loads the built-in templates, as a build without a
+// template directory would.
+func newTestTemplates(t testing.TB) *templates {
+	t.Helper()
+	urls := urlConfig{trailingSlash: "auto"}
+	tmpls, err := loadTemplates(newTemplateFS(""), "", "_", templateFuncs("", "", urls, &siteConfig{}), "base", false)
+	if err != nil {
+		t.Fatalf("loading templates: %v", err)
+	}
+	return tmpls
+}
+
+// TestRenderParallel renders many pages with the same layout at once, to
+// check (with -race) that each render gets its own copy of the layout.
+func TestRenderParallel(t *testing.T) {
+	tmpls := newTestTemplates(t)
+	enc, err := lookupCharset("utf-8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 64
+	var wg sync.WaitGroup
+	outs := make([]bytes.Buffer, n)
+	errs := make([]error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data := renderData{
+				Title:   fmt.Sprintf("Page %d", i),
+				Content: template.HTML(fmt.Sprintf("<p>content of page %d</p>", i)),
+				Path:    fmt.Sprintf("/page%d.html", i),
+				urls:    urlConfig{baseURL: fmt.Sprintf("https://example.com/site%d/", i), trailingSlash: "auto"},
+			}
+			errs[i] = tmpls.render("base", &outs[i], data, enc)
+		}()
+	}
+	wg.Wait()
+
+	for i := range n {
+		if errs[i] != nil {
+			t.Errorf("page %d: %v", i, errs[i])
+			continue
+		}
+		out := outs[i].String()
+		for _, want := range []string{
+			fmt.Sprintf("<title>Page %d</title>", i),
+			fmt.Sprintf("<p>content of page %d</p>", i),
+			fmt.Sprintf(`href="/site%d/css/main.css"`, i),
+		} {
+			if !strings.Contains(out, want) {
+				t.Errorf("page %d: output doesn't contain %q:\n%s", i, want, out)
+			}
+		}
+	}
+}
+
+func TestHardWraps(t *testing.T) {
+	const md = "first line\nsecond line\n"
+	for _, tt := range []struct {
+		hardWraps string
+		want      string
+	}{
+		{"true", "<p>first line<br>\nsecond line</p>"},
+		{"false", "<p>first line\nsecond line</p>"},
+	} {
+		t.Run("hard-wraps="+tt.hardWraps, func(t *testing.T) {
+			if got := buildPage(t, md, "hard-wraps", tt.hardWraps); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// largeMarkdown returns a generated markdown page of about n bytes, with a
+// mix of headings, paragraphs, lists, tables and code blocks.
+func largeMarkdown(n int) string {
+	var b strings.Builder
+	b.WriteString("---\ntitle: Large page\n---\n\n[[TOC]]\n\n")
+	for i := 0; b.Len() < n; i++ {
+		fmt.Fprintf(&b, "## Section %d\n\n", i)
+		fmt.Fprintf(&b, "Some *emphasized* and **strong** text, with a [link](/page%d.html) and `code`.\n", i)
+		b.WriteString("It goes on for a second line, to make a longer paragraph.\n\n")
+		fmt.Fprintf(&b, "- item %d\n- another item\n  - nested item\n\n", i)
+		b.WriteString("| Name | Value |\n|:-----|------:|\n| a | 1 |\n| b | 2 |\n\n")
+		fmt.Fprintf(&b, "```go\nfunc f%d() int {\n\treturn %d\n}\n```\n\n", i, i)
+	}
+	return b.String()
+}
+
+// BenchmarkConvertLargePage builds a site with a single large page, with
+// -dry-render so that the output isn't written.
+func BenchmarkConvertLargePage(b *testing.B) {
+	md := largeMarkdown(1 << 20)
+	src, out := newTestSite(b, map[string]string{"index.md": md})
+	setTestFlag(b, "dry-render", "true")
+
+	b.SetBytes(int64(len(md)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		if err := build(src, out); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTypographer(t *testing.T) {
+	const md = "\"quoted\" -- and --- or...\n"
+	for _, tt := range []struct {
+		typographer string
+		want        string
+	}{
+		{"true", "<p>\u201cquoted\u201d \u2013 and \u2014 or\u2026</p>"},
+		{"false", "<p>&#34;quoted&#34; -- and --- or...</p>"},
+	} {
+		t.Run("typographer="+tt.typographer, func(t *testing.T) {
+			if got := buildPage(t, md, "typographer", tt.typographer); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDraftWithInvalidDate checks that drafts are left out before their
+// dates are parsed.
+func TestDraftWithInvalidDate(t *testing.T) {
+	src, out := newTestSite(t, map[string]string{
+		"index.md": "---\ntitle: Home\n---\nHome\n",
+		"draft.md": "---\ndraft: true\ndate: not a date\n---\nDraft\n",
+	})
+
+	if err := build(src, out); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "draft.html")); !os.IsNotExist(err) {
+		t.Errorf("draft.html was written (err = %v)", err)
+	}
+
+	setTestFlag(t, "drafts", "true")
+	if err := build(src, out); err == nil || !strings.Contains(err.Error(), "draft.md") {
+		t.Errorf("build with -drafts: got error %v, want one about draft.md's date", err)
+	}
+}
+
+// TestFilterCharset checks that -filter-command is given pages as UTF-8,
+// and that its output is transcoded to the page's charset.
+func TestFilterCharset(t *testing.T) {
+	src, out := newTestSite(t, map[string]string{
+		"index.md": "---\ntitle: Home\ncharset: latin1\n---\ncafé\n",
+	})
+	seen := setTestFilter(t)
+
+	if err := build(src, out); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	b, err := os.ReadFile(seen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mainContent(string(b)), "<p>café</p>"; got != want {
+		t.Errorf("filter got %q, want %q", got, want)
+	}
+	if b, err = os.ReadFile(filepath.Join(out, "index.html")); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := mainContent(string(b)), "<p>caf\xe9</p>"; got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+}
+
+// TestFilterExtensions checks that -filter-command runs on every HTML page,
+// whatever its extension, and not on other formats.
+func TestFilterExtensions(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		files    map[string]string
+		flags    []string
+		want     []string
+		unwanted []string
+	}{
+		{
+			name:  "html",
+			files: map[string]string{"index.md": "Home page\n"},
+			want:  []string{"<p>Home page</p>"},
+		},
+		{
+			name:  "without extensions",
+			files: map[string]string{"index.md": "Home page\n"},
+			flags: []string{"with-extensions", "false"},
+			want:  []string{"<p>Home page</p>"},
+		},
+		{
+			name:  "extension directive",
+			files: map[string]string{"index.md": "---\nrp:\n  extension: htm\n---\nHome page\n"},
+			want:  []string{"<p>Home page</p>"},
+		},
+		{
+			name:     "text output",
+			files:    map[string]string{"index.md": "---\noutputs: [text]\n---\nHome page\n"},
+			unwanted: []string{"Home page"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i+1 < len(tt.flags); i += 2 {
+				setTestFlag(t, tt.flags[i], tt.flags[i+1])
+			}
+			src, out := newTestSite(t, tt.files)
+			seen := setTestFilter(t)
+			if err := build(src, out); err != nil {
+				t.Fatalf("build: %v", err)
+			}
+			b, err := os.ReadFile(seen)
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(b), want) {
+					t.Errorf("filtered pages don't contain %q:\n%s", want, b)
+				}
+			}
+			for _, unwanted := range tt.unwanted {
+				if strings.Contains(string(b), unwanted) {
+					t.Errorf("filtered pages contain %q:\n%s", unwanted, b)
+				}
+			}
+		})
+	}
+}
+
+// TestValidateHTMLExtensions checks that -validate-html checks every HTML
+// page, whatever its extension.
+func TestValidateHTMLExtensions(t *testing.T) {
+	const invalid = "<b>bold</span>\n"
+	for _, tt := range []struct {
+		name  string
+		md    string
+		flags []string
+	}{
+		{"html", "---\nrp:\n  sanitize: false\n---\n" + invalid, nil},
+		{"without extensions", "---\nrp:\n  sanitize: false\n---\n" + invalid, []string{"with-extensions", "false"}},
+		{"extension directive", "---\nrp:\n  sanitize: false\n  extension: htm\n---\n" + invalid, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			setTestFlag(t, "validate-html", "true")
+			setTestFlag(t, "strict", "true")
+			for i := 0; i+1 < len(tt.flags); i += 2 {
+				setTestFlag(t, tt.flags[i], tt.flags[i+1])
+			}
+			src, out := newTestSite(t, map[string]string{"index.md": tt.md})
+			if err := build(src, out); err == nil || !strings.Contains(err.Error(), "invalid HTML") {
+				t.Errorf("got error %v, want one about invalid HTML", err)
+			}
+		})
+	}
+}
+
+// filterOutputEnv names the file that TestFilterHelper copies the pages it
+// filters to.
+const filterOutputEnv = "RP_TEST_FILTER_OUTPUT"
+
+// setTestFilter sets -filter-command to run TestFilterHelper, and returns
+// the file that the pages it filters are appended to.
+func setTestFilter(t *testing.T) string {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(exe, " ") {
+		t.Skipf("test binary %s can't be used as a -filter-command", exe)
+	}
+	seen := filepath.Join(t.TempDir(), "filtered.html")
+	t.Setenv(filterOutputEnv, seen)
+	setTestFlag(t, "filter-command", exe+" -test.run=^TestFilterHelper$")
+	return seen
+}
+
+// TestFilterHelper isn't a real test: it's run as the -filter-command set by
+// setTestFilter, and copies its input to its output and to the end of the
+// file named by $RP_TEST_FILTER_OUTPUT.
+func TestFilterHelper(t *testing.T) {
+	name := os.Getenv(filterOutputEnv)
+	if name == "" {
+		t.Skip("only run as a -filter-command")
+	}
+	b, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err == nil {
+		_, err = f.Write(b)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(b)
+	os.Exit(0)
+}
+
+// TestAuthorPage checks that author pages are rendered inside the same page
+// as every other layout.
+func TestAuthorPage(t *testing.T) {
+	src, out := newTestSite(t, map[string]string{
+		"post.md": "---\ntitle: A post\nauthor: Jane Doe\n---\nHello\n",
+	})
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(config, []byte("theme_color: \"#336699\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setTestFlag(t, "author-pages", "true")
+	setTestFlag(t, "config", config)
+	if err := build(src, out); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(out, "authors", "jane-doe.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := string(b)
+	for _, want := range []string{
+		"<title>Jane Doe</title>",
+		`<meta name="theme-color" content="#336699">`,
+		`<a href="/post.html">A post</a>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("author page doesn't contain %q:\n%s", want, page)
+		}
+	}
+	if got := mainContent(page); !strings.HasPrefix(got, "<h1>Jane Doe</h1>") {
+		t.Errorf("author page content is %q, want the author's name and pages", got)
+	}
+}
+
+// TestURLPrefix checks that -output-prefix is added to every path passed to
+// relURL and absURL, even one that starts with the prefix, but not to page
+// URLs, which already include it.
+func TestURLPrefix(t *testing.T) {
+	urls := urlConfig{baseURL: "https://example.com/", trailingSlash: "auto", prefix: "docs"}
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"relURL", urls.relURL("/css/main.css"), "/docs/css/main.css"},
+		{"relURL prefixed", urls.relURL("/docs/x.html"), "/docs/docs/x.html"},
+		{"absURL prefixed", urls.absURL("/docs/x.html"), "https://example.com/docs/docs/x.html"},
+		{"absPageURL", urls.absPageURL("/docs/x.html"), "https://example.com/docs/x.html"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+
+	f := templateFuncs("", "", urls, &siteConfig{})["relURL"].(func(any) (string, error))
+	got, err := f(pageURL("/docs/x.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/docs/x.html"; got != want {
+		t.Errorf("relURL of a page URL = %q, want %q", got, want)
+	}
+}
+
+// TestTrailingSlashAlways checks that -trailing-slash=always writes author
+// pages as index pages, and rejects pages that aren't index pages, since
+// their links wouldn't resolve.
+func TestTrailingSlashAlways(t *testing.T) {
+	setTestFlag(t, "link-extensions", "false")
+	setTestFlag(t, "trailing-slash", "always")
+	setTestFlag(t, "author-pages", "true")
+
+	src, out := newTestSite(t, map[string]string{
+		"index.md":       "Home\n",
+		"post/index.md":  "---\ntitle: A post\nauthor: Jane Doe\n---\nHello\n",
+		"docs/_index.md": "Docs\n",
+	})
+	if err := build(src, out); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(out, "authors", "jane-doe", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<a href="/post/">A post</a>`; !strings.Contains(string(b), want) {
+		t.Errorf("author page doesn't contain %q:\n%s", want, b)
+	}
+
+	src, out = newTestSite(t, map[string]string{"about.md": "About\n"})
+	err = build(src, out)
+	if err == nil || !strings.Contains(err.Error(), "requires directory-style pages") {
+		t.Errorf("build with about.md = %v, want a directory-style pages error", err)
+	}
+}
+
+// TestSinceMetadataFile checks that -since renders a page whose metadata
+// file changed after the cutoff, even though the page itself didn't.
+func TestSinceMetadataFile(t *testing.T) {
+	src, out := newTestSite(t, map[string]string{
+		"old.md":       "Old\n",
+		"post.md":      "Hello\n",
+		"post.md.yaml": "title: A post\n",
+	})
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"old.md", "post.md"} {
+		if err := os.Chtimes(filepath.Join(src, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setTestFlag(t, "since", "1h")
+	if err := build(src, out); err != nil {
+		t.Fatalf("build: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "post.html")); err != nil {
+		t.Errorf("page with a changed metadata file wasn't rendered: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "old.html")); !os.IsNotExist(err) {
+		t.Errorf("unchanged page was rendered (err = %v)", err)
+	}
+}
+
+// TestCleanOnlyPrefix checks that -clean-only validates -output-prefix as
+// the build does, so that it never cleans the whole output.
+func TestCleanOnlyPrefix(t *testing.T) {
+	for _, prefix := range []string{".", "docs/..", "../docs", "docs?x"} {
+		src, out := newTestSite(t, map[string]string{"index.md": "Home\n"})
+		keep := filepath.Join(out, "other.html")
+		if err := os.WriteFile(keep, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		setTestFlag(t, "output-prefix", prefix)
+		if err := cleanOnly(src, out); err == nil {
+			t.Errorf("cleanOnly with -output-prefix %q succeeded; want an error", prefix)
+		}
+		if _, err := os.Stat(keep); err != nil {
+			t.Errorf("-output-prefix %q: %v", prefix, err)
+		}
+	}
+}
+
+// TestTimeAgoDateFormats checks that timeAgo parses dates with the site's
+// date_formats, as frontmatter dates are.
+func TestTimeAgoDateFormats(t *testing.T) {
+	cfg := &siteConfig{DateFormats: []string{"02/01/2006"}}
+	timeAgo := templateFuncs("", "", urlConfig{trailingSlash: "auto"}, cfg)["timeAgo"].(func(any, time.Time) (string, error))
+	now := time.Date(2024, 3, 15, 0, 0, 0, 0, time.Local)
+	got, err := timeAgo("12/03/2024", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "3 days ago"; got != want {
+		t.Errorf("timeAgo = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// codeBlocks returns a goldmark extension that renders fenced code blocks.
+//
+// Code blocks with a 'title' attribute in their info string, e.g.
+//
+//	```go title="main.go"
+//
+// are rendered inside a <figure>, with the title as its <figcaption>. If
+// diagrams is non-nil, "mermaid" and "math" code blocks are rendered with
+// it (see diagramRenderer). Other code blocks are rendered exactly as goldmark normally renders
+// them.
+func codeBlocks(diagrams *diagramRenderer) goldmark.Extender {
+	return &codeBlocksExt{diagrams: diagrams}
+}
+
+type codeBlocksExt struct {
+	diagrams *diagramRenderer
+}
+
+func (e *codeBlocksExt) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		// Lower values take precedence over goldmark's HTML renderer,
+		// which has a priority of 1000.
+		util.Prioritized(&codeBlockRenderer{writer: html.DefaultWriter, diagrams: e.diagrams}, 500),
+	))
+}
+
+type codeBlockRenderer struct {
+	writer   html.Writer
+	diagrams *diagramRenderer
+}
+
+func (r *codeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *codeBlockRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*ast.FencedCodeBlock)
+	var title []byte
+	if n.Info != nil {
+		title = infoAttrs(n.Info.Segment.Value(source))["title"]
+	}
+	language := n.Language(source)
+
+	// Diagrams are rendered in one go, when entering the node.
+	if kind := string(language); r.diagrams.handles(kind) {
+		if entering {
+			var src bytes.Buffer
+			for i := 0; i < n.Lines().Len(); i++ {
+				line := n.Lines().At(i)
+				src.Write(line.Value(source))
+			}
+			out, ok := r.diagrams.render(kind, src.String())
+			if !ok {
+				n.OwnerDocument().SetAttributeString(clientSideDiagramsAttr, true)
+			}
+			w.WriteString(out)
+		}
+		return ast.WalkSkipChildren, nil
+	}
+
+	if !entering {
+		w.WriteString("</code></pre>\n")
+		if title != nil {
+			w.WriteString("</figure>\n")
+		}
+		return ast.WalkContinue, nil
+	}
+
+	if title != nil {
+		w.WriteString("<figure>\n<figcaption>")
+		r.writer.Write(w, title)
+		w.WriteString("</figcaption>\n")
+	}
+	w.WriteString("<pre><code")
+	if language != nil {
+		w.WriteString(` class="language-`)
+		r.writer.Write(w, language)
+		w.WriteString(`"`)
+	}
+	w.WriteByte('>')
+	for i := 0; i < n.Lines().Len(); i++ {
+		line := n.Lines().At(i)
+		r.writer.RawWrite(w, line.Value(source))
+	}
+	return ast.WalkContinue, nil
+}
+
+// infoAttrRe matches a key=value attribute in a fenced code block's info
+// string; the value may be double-quoted, single-quoted, or bare.
+var infoAttrRe = regexp.MustCompile(`([A-Za-z][\w-]*)=(?:"([^"]*)"|'([^']*)'|(\S+))`)
+
+// infoAttrs parses the key=value attributes that follow the language in a
+// fenced code block's info string.
+func infoAttrs(info []byte) map[string][]byte {
+	var ret map[string][]byte
+	for _, m := range infoAttrRe.FindAllSubmatch(info, -1) {
+		if ret == nil {
+			ret = make(map[string][]byte)
+		}
+		var value []byte
+		for _, v := range m[2:] {
+			if v != nil {
+				value = v
+				break
+			}
+		}
+		ret[string(m[1])] = value
+	}
+	return ret
+}
+
+// blockAttributes is a goldmark extension that applies attribute lists (e.g.
+// "{.warning #note data-x=y}") to block elements. goldmark's own
+// parser.WithAttribute only supports them on headings; this extends that to
+// other blocks:
+//
+//   - an attribute list on the last line of a paragraph applies to that
+//     paragraph;
+//   - an attribute list on its own, in a paragraph by itself, applies to the
+//     block immediately before it (e.g. a blockquote or a list).
+var blockAttributes goldmark.Extender = &blockAttributesExt{}
+
+type blockAttributesExt struct{}
+
+func (e *blockAttributesExt) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(
+		parser.WithAttribute(),
+		parser.WithASTTransformers(util.Prioritized(blockAttributesExt{}, 500)),
+	)
+}
+
+func (blockAttributesExt) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	// Collect the paragraphs first, since we may remove some of them.
+	var paras []*ast.Paragraph
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if p, ok := n.(*ast.Paragraph); ok && entering {
+			paras = append(paras, p)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, p := range paras {
+		lines := p.Lines()
+		if lines.Len() == 0 {
+			continue
+		}
+		last := lines.At(lines.Len() - 1)
+		line := bytes.TrimSpace(last.Value(source))
+		if len(line) == 0 || line[0] != '{' || line[len(line)-1] != '}' {
+			continue
+		}
+		r := text.NewReader(line)
+		attrs, ok := parser.ParseAttributes(r)
+		if !ok {
+			continue
+		}
+		if rest, _ := r.PeekLine(); len(bytes.TrimSpace(rest)) > 0 {
+			continue // trailing text after the closing brace
+		}
+
+		if lines.Len() == 1 {
+			target := p.PreviousSibling()
+			if target == nil {
+				continue
+			}
+			setAttributes(target, attrs)
+			p.Parent().RemoveChild(p.Parent(), p)
+			continue
+		}
+
+		// Remove the inline nodes that came from the attribute line, and
+		// the line break before it.
+		for c := p.LastChild(); c != nil; c = p.LastChild() {
+			if t := firstText(c); t != nil && t.Segment.Start < last.Start {
+				break
+			}
+			p.RemoveChild(p, c)
+		}
+		if t, ok := p.LastChild().(*ast.Text); ok {
+			t.SetSoftLineBreak(false)
+			t.SetHardLineBreak(false)
+		}
+		setAttributes(p, attrs)
+	}
+}
+
+func setAttributes(n ast.Node, attrs parser.Attributes) {
+	for _, attr := range attrs {
+		n.SetAttribute(attr.Name, attr.Value)
+	}
+}
+
+// firstText returns the first text node in n (which may be n itself), or
+// nil if it contains none.
+func firstText(n ast.Node) *ast.Text {
+	if t, ok := n.(*ast.Text); ok {
+		return t
+	}
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if t := firstText(c); t != nil {
+			return t
+		}
+	}
+	return nil
+}
+
+// alerts is a goldmark extension that renders GitHub-style alerts, i.e.
+// blockquotes whose first line is "[!NOTE]", "[!WARNING]" and so on, as
+// callouts:
+//
+//	<div class="callout callout-note">
+//	<p class="callout-title"><span class="callout-icon">ℹ</span> Note</p>
+//	...
+//	</div>
+//
+// Blockquotes with an unknown alert type are left as they are.
+var alerts goldmark.Extender = &alertsExt{}
+
+// alertTypes maps each supported alert type to its title and icon.
+var alertTypes = map[string]struct{ title, icon string }{
+	"NOTE":      {"Note", "ℹ"},
+	"TIP":       {"Tip", "💡"},
+	"IMPORTANT": {"Important", "❗"},
+	"WARNING":   {"Warning", "⚠"},
+	"CAUTION":   {"Caution", "🛑"},
+}
+
+// alertMarkerRe matches the first line of a blockquote that's an alert.
+var alertMarkerRe = regexp.MustCompile(`^\[!([A-Za-z]+)\]$`)
+
+var kindAlert = ast.NewNodeKind("Alert")
+
+// alertNode is a callout, which replaces the blockquote that it was written
+// as.
+type alertNode struct {
+	ast.BaseBlock
+	alertType string // a key of alertTypes
+}
+
+func (n *alertNode) Kind() ast.NodeKind { return kindAlert }
+
+func (n *alertNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Type": n.alertType}, nil)
+}
+
+type alertsExt struct{}
+
+func (e *alertsExt) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(alertsExt{}, 500)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(alertsExt{}, 500)))
+}
+
+func (alertsExt) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	var quotes []*ast.Blockquote
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if q, ok := n.(*ast.Blockquote); ok && entering {
+			quotes = append(quotes, q)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, q := range quotes {
+		p, ok := q.FirstChild().(*ast.Paragraph)
+		if !ok || p.Lines().Len() == 0 {
+			continue
+		}
+		first := p.Lines().At(0)
+		m := alertMarkerRe.FindSubmatch(bytes.TrimSpace(first.Value(source)))
+		if m == nil {
+			continue
+		}
+		alertType := strings.ToUpper(string(m[1]))
+		if _, ok := alertTypes[alertType]; !ok {
+			continue
+		}
+
+		// Remove the marker line, and the paragraph too if that was
+		// all that it contained.
+		for c := p.FirstChild(); c != nil; c = p.FirstChild() {
+			if t := firstText(c); t == nil || t.Segment.Start >= first.Stop {
+				break
+			}
+			p.RemoveChild(p, c)
+		}
+		if p.ChildCount() == 0 {
+			q.RemoveChild(q, p)
+		}
+
+		alert := &alertNode{alertType: alertType}
+		for c := q.FirstChild(); c != nil; c = q.FirstChild() {
+			q.RemoveChild(q, c)
+			alert.AppendChild(alert, c)
+		}
+		q.Parent().ReplaceChild(q.Parent(), q, alert)
+	}
+}
+
+func (alertsExt) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindAlert, func(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			w.WriteString("</div>\n")
+			return ast.WalkContinue, nil
+		}
+		n := node.(*alertNode)
+		t := alertTypes[n.alertType]
+		fmt.Fprintf(w, `<div class="callout callout-%s">`+"\n", strings.ToLower(n.alertType))
+		fmt.Fprintf(w, `<p class="callout-title"><span class="callout-icon">%s</span> %s</p>`+"\n", t.icon, t.title)
+		return ast.WalkContinue, nil
+	})
+}
+
+// tableCaptions is a goldmark extension that gives a table a caption, from a
+// paragraph directly after it that starts with "Table:":
+//
+//	| a | b |
+//	|---|---|
+//	| 1 | 2 |
+//
+//	Table: Some numbers
+//
+// The caption is rendered as the table's <caption>, and may contain inline
+// markdown.
+var tableCaptions goldmark.Extender = &tableCaptionsExt{}
+
+// tableCaptionPrefix starts a paragraph that's a table caption.
+const tableCaptionPrefix = "Table:"
+
+var kindTableCaption = ast.NewNodeKind("TableCaption")
+
+// tableCaptionNode is a table's caption; it's the first child of the table.
+type tableCaptionNode struct {
+	ast.BaseBlock
+}
+
+func (n *tableCaptionNode) Kind() ast.NodeKind { return kindTableCaption }
+
+func (n *tableCaptionNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+type tableCaptionsExt struct{}
+
+func (e *tableCaptionsExt) Extend(m goldmark.Markdown) {
+	// This must run after the table extension's own transformer, which
+	// turns paragraphs into tables and has a priority of 0.
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(tableCaptionsExt{}, 500)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(tableCaptionsExt{}, 500)))
+}
+
+func (tableCaptionsExt) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	var tables []*east.Table
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if t, ok := n.(*east.Table); ok && entering {
+			tables = append(tables, t)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, table := range tables {
+		p, ok := table.NextSibling().(*ast.Paragraph)
+		if !ok {
+			continue
+		}
+		first, ok := p.FirstChild().(*ast.Text)
+		if !ok || !bytes.HasPrefix(first.Segment.Value(source), []byte(tableCaptionPrefix)) {
+			continue
+		}
+
+		// Drop the prefix, and any space after it.
+		seg := first.Segment.WithStart(first.Segment.Start + len(tableCaptionPrefix))
+		for seg.Start < seg.Stop && (source[seg.Start] == ' ' || source[seg.Start] == '\t') {
+			seg = seg.WithStart(seg.Start + 1)
+		}
+		first.Segment = seg
+
+		caption := &tableCaptionNode{}
+		for c := p.FirstChild(); c != nil; c = p.FirstChild() {
+			p.RemoveChild(p, c)
+			caption.AppendChild(caption, c)
+		}
+		p.Parent().RemoveChild(p.Parent(), p)
+		table.InsertBefore(table, table.FirstChild(), caption)
+	}
+}
+
+func (tableCaptionsExt) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindTableCaption, func(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			w.WriteString("<caption>")
+		} else {
+			w.WriteString("</caption>\n")
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// abbreviations is a goldmark extension for abbreviation definitions, as in
+// PHP Markdown Extra:
+//
+//	The HTML specification is maintained by the W3C.
+//
+//	*[HTML]: HyperText Markup Language
+//	*[W3C]: World Wide Web Consortium
+//
+// Definitions can be anywhere in the document, in paragraphs of their own,
+// and are removed from the output. Every occurrence of a defined term as a
+// whole word, outside of code, is then wrapped in an <abbr> element with the
+// definition as its title.
+var abbreviations goldmark.Extender = &abbreviationsExt{}
+
+// abbrDefRe matches a line that defines an abbreviation.
+var abbrDefRe = regexp.MustCompile(`^\*\[([^\]]+)\]:[ \t]*(.*?)[ \t]*$`)
+
+var kindAbbr = ast.NewNodeKind("Abbr")
+
+// abbrNode is an abbreviation; its children are the abbreviated text.
+type abbrNode struct {
+	ast.BaseInline
+	title string
+}
+
+func (n *abbrNode) Kind() ast.NodeKind { return kindAbbr }
+
+func (n *abbrNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Title": n.title}, nil)
+}
+
+type abbreviationsExt struct{}
+
+func (e *abbreviationsExt) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(abbreviationsExt{}, 500)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(abbreviationsExt{}, 500)))
+}
+
+func (abbreviationsExt) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+
+	// Collect and remove the definitions. A later definition of the same
+	// term wins.
+	defs := make(map[string]string)
+	var defParas []*ast.Paragraph
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		p, ok := n.(*ast.Paragraph)
+		if !ok || p.Lines().Len() == 0 {
+			continue
+		}
+		found := make(map[string]string)
+		for i := 0; i < p.Lines().Len(); i++ {
+			line := p.Lines().At(i)
+			m := abbrDefRe.FindSubmatch(bytes.TrimRight(line.Value(source), "\r\n"))
+			if m == nil {
+				found = nil
+				break
+			}
+			found[strings.TrimSpace(string(m[1]))] = string(m[2])
+		}
+		if found != nil {
+			for term, title := range found {
+				defs[term] = title
+			}
+			defParas = append(defParas, p)
+		}
+	}
+	for _, p := range defParas {
+		p.Parent().RemoveChild(p.Parent(), p)
+	}
+	if len(defs) == 0 {
+		return
+	}
+
+	// Match longer terms first, so that e.g. "HTML5" wins over "HTML".
+	terms := make([]string, 0, len(defs))
+	for term := range defs {
+		terms = append(terms, term)
+	}
+	slices.SortFunc(terms, func(a, b string) int {
+		return cmp.Or(cmp.Compare(len(b), len(a)), strings.Compare(a, b))
+	})
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = regexp.QuoteMeta(term)
+	}
+	termRe := regexp.MustCompile(strings.Join(quoted, "|"))
+
+	// Collect the text first, since we replace it as we go.
+	var texts []*ast.Text
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.CodeSpan, *abbrNode:
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			if !n.IsRaw() {
+				texts = append(texts, n)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	for _, t := range texts {
+		abbreviateText(t, source, termRe, terms, defs)
+	}
+}
+
+// abbreviateText splits t around every whole-word match of termRe, wrapping
+// each match in an abbrNode. Where a term isn't a whole word, the shorter
+// terms in terms (which is sorted longest first) that start at the same
+// place are tried before moving on.
+func abbreviateText(t *ast.Text, source []byte, termRe *regexp.Regexp, terms []string, defs map[string]string) {
+	seg := t.Segment
+	value := seg.Value(source)
+	isWord := func(i int) bool {
+		if i < 0 || i >= len(value) {
+			return false
+		}
+		r, _ := utf8.DecodeRune(value[i:])
+		return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+	}
+	lastRune := func(i int) int {
+		_, size := utf8.DecodeLastRune(value[:i])
+		return i - size
+	}
+
+	// match returns the end of the longest term that's a whole word
+	// starting at i, or -1 if there isn't one.
+	match := func(i int) int {
+		if i > 0 && isWord(lastRune(i)) {
+			return -1
+		}
+		for _, term := range terms {
+			if end := i + len(term); bytes.HasPrefix(value[i:], []byte(term)) && !isWord(end) {
+				return end
+			}
+		}
+		return -1
+	}
+
+	parent := t.Parent()
+	start := 0
+	for pos := 0; pos < len(value); {
+		loc := termRe.FindIndex(value[pos:])
+		if loc == nil {
+			break
+		}
+		m0 := pos + loc[0]
+		m1 := match(m0)
+		if m1 < 0 {
+			_, size := utf8.DecodeRune(value[m0:])
+			pos = m0 + size
+			continue
+		}
+		if m0 > start {
+			parent.InsertBefore(parent, t, ast.NewTextSegment(text.NewSegment(seg.Start+start, seg.Start+m0)))
+		}
+		abbr := &abbrNode{title: defs[string(value[m0:m1])]}
+		abbr.AppendChild(abbr, ast.NewTextSegment(text.NewSegment(seg.Start+m0, seg.Start+m1)))
+		parent.InsertBefore(parent, t, abbr)
+		start, pos = m1, m1
+	}
+	if start > 0 {
+		// The original node keeps the rest of the text, and any line
+		// break after it.
+		t.Segment = seg.WithStart(seg.Start + start)
+		if t.Segment.IsEmpty() && !t.SoftLineBreak() && !t.HardLineBreak() {
+			parent.RemoveChild(parent, t)
+		}
+	}
+}
+
+func (abbreviationsExt) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindAbbr, func(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if entering {
+			w.WriteString(`<abbr title="`)
+			w.Write(util.EscapeHTML([]byte(node.(*abbrNode).title)))
+			w.WriteString(`">`)
+		} else {
+			w.WriteString("</abbr>")
+		}
+		return ast.WalkContinue, nil
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBlockAttributes(t *testing.T) {
+	for _, tt := range []struct {
+		name, md, want string
+	}{
+		{
+			name: "blockquote",
+			md:   "> Careful!\n\n{.warning}\n",
+			want: "<blockquote class=\"warning\"><p>Careful!</p>\n</blockquote>",
+		},
+		{
+			name: "paragraph",
+			md:   "Careful!\n{.warning #note}\n",
+			want: `<p class="warning" id="note">Careful!</p>`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildPage(t, tt.md); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableAlignment(t *testing.T) {
+	const md = "| Left | Center | Right |\n|:-----|:------:|------:|\n| a | b | c |\n\nTable: Totals\n"
+	got := buildPage(t, md)
+	for _, want := range []string{
+		"<caption>Totals</caption>",
+		`<th align="left">Left</th>`,
+		`<th align="center">Center</th>`,
+		`<th align="right">Right</th>`,
+		`<td align="center">b</td>`,
+		`<td align="right">c</td>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestAbbreviations(t *testing.T) {
+	for _, tt := range []struct {
+		name, md, want string
+	}{
+		{
+			name: "longest first",
+			md:   "HTML5 and HTML\n\n*[HTML]: HyperText Markup Language\n*[HTML5]: HTML version 5\n",
+			want: `<p><abbr title="HTML version 5">HTML5</abbr> and <abbr title="HyperText Markup Language">HTML</abbr></p>`,
+		},
+		{
+			name: "not a word",
+			md:   "HTMLish\n\n*[HTML]: HyperText Markup Language\n",
+			want: `<p>HTMLish</p>`,
+		},
+		{
+			name: "shorter term at the same place",
+			md:   "C++x and C++\n\n*[C]: The C language\n*[C++]: The C++ language\n",
+			want: `<p><abbr title="The C language">C</abbr>++x and <abbr title="The C++ language">C++</abbr></p>`,
+		},
+		{
+			name: "overlapping term",
+			md:   "xfoo.bar\n\n*[foo.bar]: Foo bar\n*[bar]: Bar\n",
+			want: `<p>xfoo.<abbr title="Bar">bar</abbr></p>`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildPage(t, tt.md); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
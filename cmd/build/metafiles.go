@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// metadataFileExts are the extensions of a page's metadata file, which is
+// named after the page, e.g. "post.md.yaml" for "post.md".
+var metadataFileExts = []string{".yaml", ".yml", ".json"}
+
+// isMetadataFile reports whether the file at p is the metadata file of a
+// markdown page that exists, so that it isn't copied to the output.
+func isMetadataFile(p string) bool {
+	page, ok := strings.CutSuffix(p, filepath.Ext(p))
+	if !ok || filepath.Ext(page) != ".md" || !slices.Contains(metadataFileExts, filepath.Ext(p)) {
+		return false
+	}
+	_, err := os.Stat(page)
+	return err == nil
+}
+
+// loadMetadataFile returns the contents of the metadata file for the page
+// src, or nil if it doesn't have one. JSON files are parsed as YAML, so that
+// their values have the same types as frontmatter.
+func loadMetadataFile(src string) (map[string]any, error) {
+	var found string
+	var data []byte
+	for _, ext := range metadataFileExts {
+		b, err := os.ReadFile(src + ext)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if found != "" {
+			return nil, fmt.Errorf("page has two metadata files, %s and %s", found, src+ext)
+		}
+		found, data = src+ext, b
+	}
+	if found == "" {
+		return nil, nil
+	}
+	meta := make(map[string]any)
+	if err := yaml.Unmarshal(data, &meta); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", found, err)
+	}
+	return meta, nil
+}
+
+// metadataModTime returns the modification time of the metadata file for
+// the page src, or the zero time if it doesn't have one.
+func metadataModTime(src string) (time.Time, error) {
+	var t time.Time
+	for _, ext := range metadataFileExts {
+		fi, err := os.Stat(src + ext)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(t) {
+			t = fi.ModTime()
+		}
+	}
+	return t, nil
+}
+
+// withMetadataFile returns the page's frontmatter merged with the contents
+// of its metadata file, fileMeta. Neither is modified. With
+// -metadata-precedence=file, the metadata file's values win.
+func (g *mdGenerator) withMetadataFile(frontmatter, fileMeta map[string]any) map[string]any {
+	low, high := fileMeta, frontmatter
+	if g.metaFileWins {
+		low, high = high, low
+	}
+	merged := make(map[string]any, len(frontmatter)+len(fileMeta))
+	mergeMeta(merged, low)
+	mergeMeta(merged, high)
+	return merged
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// outputWriter is the destination for all generated and copied files.
+type outputWriter interface {
+	// WriteFile writes the contents of r to the file name, which is
+	// relative to the root of the output. If mode is zero, the output's
+	// default file mode (-file-mode) is used; if modTime is the zero
+	// time, the current time is used.
+	WriteFile(name string, r io.Reader, mode fs.FileMode, modTime time.Time) error
+
+	// Close flushes any buffered output.
+	Close() error
+
+	// Abort discards the output after a failed build, instead of
+	// closing it: a partially written archive is removed. Files already
+	// written to a directory are left as they are.
+	Abort()
+}
+
+// outputModes are the permissions of the files and directories that are
+// written to the output.
+type outputModes struct {
+	// file is the mode of files written without an explicit mode.
+	file fs.FileMode
+	// dir is the mode of directories created in the output directory.
+	dir fs.FileMode
+}
+
+// fileMode returns mode, or the default file mode if mode is zero.
+func (m outputModes) fileMode(mode fs.FileMode) fs.FileMode {
+	if mode == 0 {
+		return m.file
+	}
+	return mode
+}
+
+// parseMode parses an octal permission string such as "0644".
+func parseMode(s string) (fs.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0777 {
+		return 0, fmt.Errorf("%q is not a valid octal permission mode (e.g. 0644)", s)
+	}
+	return fs.FileMode(n), nil
+}
+
+// newOutputWriter returns an outputWriter that writes to an archive if
+// archivePath is non-empty, and to the directory outDir otherwise.
+func newOutputWriter(outDir, archivePath string, modes outputModes) (outputWriter, error) {
+	if archivePath == "" {
+		return &dirOutput{root: outDir, modes: modes}, nil
+	}
+
+	f, err := os.Create(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case strings.HasSuffix(archivePath, ".zip"):
+		return &zipOutput{f: f, zw: zip.NewWriter(f), modes: modes}, nil
+	case strings.HasSuffix(archivePath, ".tar.gz"), strings.HasSuffix(archivePath, ".tgz"):
+		gz := gzip.NewWriter(f)
+		return &tarOutput{f: f, gz: gz, tw: tar.NewWriter(gz), modes: modes}, nil
+	case strings.HasSuffix(archivePath, ".tar"):
+		return &tarOutput{f: f, tw: tar.NewWriter(f), modes: modes}, nil
+	default:
+		f.Close()
+		os.Remove(archivePath)
+		return nil, fmt.Errorf("unknown archive type for %s; must be .zip, .tar, .tar.gz or .tgz", archivePath)
+	}
+}
+
+// prefixOutput writes every file to a subdirectory of another output; see
+// -output-prefix.
+type prefixOutput struct {
+	outputWriter
+	prefix string
+}
+
+func (p prefixOutput) WriteFile(name string, r io.Reader, mode fs.FileMode, modTime time.Time) error {
+	return p.outputWriter.WriteFile(filepath.Join(p.prefix, name), r, mode, modTime)
+}
+
+// dirOutput writes files into a directory on disk.
+type dirOutput struct {
+	root  string
+	modes outputModes
+}
+
+// WriteFile writes to a temporary file in the destination directory, and
+// then renames it into place, so that nothing reading the output directory
+// (e.g. a web server) ever sees a partially-written file.
+func (d *dirOutput) WriteFile(name string, r io.Reader, mode fs.FileMode, modTime time.Time) (err error) {
+	dst := filepath.Join(d.root, name)
+	if err := mkdirAllMode(filepath.Dir(dst), d.modes.dir); err != nil {
+		return err
+	}
+
+	tf, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tf.Close()
+			os.Remove(tf.Name())
+		}
+	}()
+
+	if _, err := io.Copy(tf, r); err != nil {
+		return err
+	}
+	if err := tf.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tf.Name(), d.modes.fileMode(mode)); err != nil {
+		return err
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(tf.Name(), modTime, modTime); err != nil {
+			return err
+		}
+	}
+	return os.Rename(tf.Name(), dst)
+}
+
+func (d *dirOutput) Close() error { return nil }
+
+func (d *dirOutput) Abort() {}
+
+// mkdirAllMode is like os.MkdirAll, but sets the mode of each directory
+// that it creates regardless of the umask.
+func mkdirAllMode(dir string, mode fs.FileMode) error {
+	if st, err := os.Stat(dir); err == nil {
+		if !st.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dir, Err: syscall.ENOTDIR}
+		}
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := mkdirAllMode(parent, mode); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dir, mode); err != nil {
+		// Another writer may have created it concurrently.
+		if st, serr := os.Stat(dir); serr == nil && st.IsDir() {
+			return nil
+		}
+		return err
+	}
+	return os.Chmod(dir, mode)
+}
+
+// tarOutput writes files into a (possibly gzip-compressed) tar archive.
+type tarOutput struct {
+	f     *os.File
+	gz    *gzip.Writer // nil if uncompressed
+	tw    *tar.Writer
+	modes outputModes
+}
+
+func (t *tarOutput) WriteFile(name string, r io.Reader, mode fs.FileMode, modTime time.Time) error {
+	// We need to know the size of the file before writing the header.
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	if err := t.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     filepath.ToSlash(name),
+		Size:     int64(len(data)),
+		Mode:     int64(t.modes.fileMode(mode).Perm()),
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+	_, err = t.tw.Write(data)
+	return err
+}
+
+func (t *tarOutput) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if t.gz != nil {
+		if err := t.gz.Close(); err != nil {
+			return err
+		}
+	}
+	return t.f.Close()
+}
+
+func (t *tarOutput) Abort() {
+	t.f.Close()
+	os.Remove(t.f.Name())
+}
+
+// zipOutput writes files into a zip archive.
+type zipOutput struct {
+	f     *os.File
+	zw    *zip.Writer
+	modes outputModes
+}
+
+func (z *zipOutput) WriteFile(name string, r io.Reader, mode fs.FileMode, modTime time.Time) error {
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	hdr := &zip.FileHeader{
+		Name:     filepath.ToSlash(name),
+		Method:   zip.Deflate,
+		Modified: modTime,
+	}
+	hdr.SetMode(z.modes.fileMode(mode))
+	w, err := z.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z *zipOutput) Close() error {
+	if err := z.zw.Close(); err != nil {
+		return err
+	}
+	return z.f.Close()
+}
+
+func (z *zipOutput) Abort() {
+	z.f.Close()
+	os.Remove(z.f.Name())
+}
+
+// discardOutput is an outputWriter that discards everything written to it,
+// for -dry-render.
+type discardOutput struct{}
+
+func (discardOutput) WriteFile(name string, r io.Reader, mode fs.FileMode, modTime time.Time) error {
+	_, err := io.Copy(io.Discard, r)
+	return err
+}
+
+func (discardOutput) Close() error { return nil }
+
+func (discardOutput) Abort() {}
+
+// writeOutputBytes is a convenience wrapper around WriteFile for in-memory
+// content.
+func writeOutputBytes(out outputWriter, name string, data []byte) error {
+	return out.WriteFile(name, bytes.NewReader(data), 0, time.Time{})
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testModes = outputModes{file: 0o644, dir: 0o755}
+
+// testOutputFiles are written to each output in TestOutputWriters.
+var testOutputFiles = map[string]string{
+	"index.html":     "home",
+	"blog/post.html": "post",
+}
+
+func TestOutputWriters(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// open returns the output to write to, given a temporary
+		// directory.
+		open func(t *testing.T, dir string) outputWriter
+		// read returns the contents of each file that was written.
+		read    func(t *testing.T, dir string) map[string]string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name: "directory",
+			open: func(t *testing.T, dir string) outputWriter {
+				return mustOutputWriter(t, filepath.Join(dir, "out"), "")
+			},
+			read: readOutputDir("out"),
+			want: testOutputFiles,
+		},
+		{
+			name: "tar",
+			open: func(t *testing.T, dir string) outputWriter {
+				return mustOutputWriter(t, "", filepath.Join(dir, "site.tar"))
+			},
+			read: readTar("site.tar", false),
+			want: testOutputFiles,
+		},
+		{
+			name: "tar.gz",
+			open: func(t *testing.T, dir string) outputWriter {
+				return mustOutputWriter(t, "", filepath.Join(dir, "site.tar.gz"))
+			},
+			read: readTar("site.tar.gz", true),
+			want: testOutputFiles,
+		},
+		{
+			name: "zip",
+			open: func(t *testing.T, dir string) outputWriter {
+				return mustOutputWriter(t, "", filepath.Join(dir, "site.zip"))
+			},
+			read: readZip("site.zip"),
+			want: testOutputFiles,
+		},
+		{
+			name: "discard",
+			open: func(t *testing.T, dir string) outputWriter {
+				return discardOutput{}
+			},
+			read: readOutputDir("."),
+			want: map[string]string{},
+		},
+		{
+			name: "prefix",
+			open: func(t *testing.T, dir string) outputWriter {
+				return prefixOutput{mustOutputWriter(t, "", filepath.Join(dir, "site.zip")), "docs"}
+			},
+			read: readZip("site.zip"),
+			want: map[string]string{
+				"docs/index.html":     "home",
+				"docs/blog/post.html": "post",
+			},
+		},
+		{
+			name: "flat",
+			open: func(t *testing.T, dir string) outputWriter {
+				return newFlatOutput(mustOutputWriter(t, filepath.Join(dir, "out"), ""))
+			},
+			read: readOutputDir("out"),
+			want: map[string]string{
+				"index.html":     "home",
+				"blog-post.html": "post",
+			},
+		},
+		{
+			name: "guarded",
+			open: func(t *testing.T, dir string) outputWriter {
+				return guardedOutput{mustOutputWriter(t, filepath.Join(dir, "out"), ""), filepath.Join(dir, "out"), mustGuard(t, filepath.Join(dir, "content"))}
+			},
+			read: readOutputDir("out"),
+			want: testOutputFiles,
+		},
+		{
+			name: "guarded in source",
+			open: func(t *testing.T, dir string) outputWriter {
+				out := filepath.Join(dir, "content", "out")
+				return guardedOutput{mustOutputWriter(t, out, ""), out, mustGuard(t, filepath.Join(dir, "content"))}
+			},
+			read:    readOutputDir("content/out"),
+			want:    map[string]string{},
+			wantErr: "refusing to write output file",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.Mkdir(filepath.Join(dir, "content"), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			out := tt.open(t, dir)
+			for _, name := range []string{"index.html", "blog/post.html"} {
+				err := writeOutputBytes(out, filepath.FromSlash(name), []byte(testOutputFiles[name]))
+				switch {
+				case tt.wantErr == "" && err != nil:
+					t.Errorf("writing %s: %v", name, err)
+				case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+					t.Errorf("writing %s: got error %v, want one containing %q", name, err, tt.wantErr)
+				}
+			}
+			if err := out.Close(); err != nil {
+				t.Fatalf("closing output: %v", err)
+			}
+			if got := tt.read(t, dir); !maps.Equal(got, tt.want) {
+				t.Errorf("got files %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlatOutputCollision(t *testing.T) {
+	out := newFlatOutput(discardOutput{})
+	if err := writeOutputBytes(out, "blog-post.html", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeOutputBytes(out, filepath.FromSlash("blog/post.html"), nil); err == nil {
+		t.Error("writing blog/post.html after blog-post.html succeeded; want an error")
+	}
+}
+
+// TestFailedBuildRemovesArchive checks that a build that fails after the
+// archive has been created doesn't leave a partial archive behind.
+func TestFailedBuildRemovesArchive(t *testing.T) {
+	src, _ := newTestSite(t, map[string]string{
+		"index.md":  "Home\n",
+		"_index.md": "Also home\n",
+	})
+	archive := filepath.Join(t.TempDir(), "site.zip")
+	setTestFlag(t, "archive", archive)
+	if err := build(src, ""); err == nil {
+		t.Fatal("build with two home pages succeeded; want an error")
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("archive exists after a failed build (err = %v)", err)
+	}
+}
+
+func mustOutputWriter(t *testing.T, outDir, archivePath string) outputWriter {
+	t.Helper()
+	out, err := newOutputWriter(outDir, archivePath, testModes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func mustGuard(t *testing.T, dir string) *readonlyGuard {
+	t.Helper()
+	g, err := newReadonlyGuard(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+// readOutputDir returns a function that reads every file under the
+// slash-separated directory name in a test's temporary directory.
+func readOutputDir(name string) func(t *testing.T, dir string) map[string]string {
+	return func(t *testing.T, dir string) map[string]string {
+		t.Helper()
+		root := filepath.Join(dir, filepath.FromSlash(name))
+		files := make(map[string]string)
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if p == root && os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(root, p)
+			files[filepath.ToSlash(rel)] = string(b)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+}
+
+// readTar returns a function that reads every file in the named tar archive
+// in a test's temporary directory.
+func readTar(name string, gzipped bool) func(t *testing.T, dir string) map[string]string {
+	return func(t *testing.T, dir string) map[string]string {
+		t.Helper()
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if gzipped {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r = gz
+		}
+		files := make(map[string]string)
+		tr := tar.NewReader(r)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fs.FileMode(hdr.Mode) != testModes.file {
+				t.Errorf("%s has mode %v, want %v", hdr.Name, fs.FileMode(hdr.Mode), testModes.file)
+			}
+			b, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[hdr.Name] = string(b)
+		}
+		return files
+	}
+}
+
+// readZip returns a function that reads every file in the named zip archive
+// in a test's temporary directory.
+func readZip(name string) func(t *testing.T, dir string) map[string]string {
+	return func(t *testing.T, dir string) map[string]string {
+		t.Helper()
+		zr, err := zip.OpenReader(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		files := make(map[string]string)
+		for _, f := range zr.File {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(rc)
+			rc.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[f.Name] = string(b)
+		}
+		return files
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"html/template"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// page is a markdown page that has been converted to HTML, but not yet
+// rendered with its layout.
+type page struct {
+	// src is the path to the markdown file.
+	src string
+	// relPath is the path of the rendered page, relative to the output
+	// root.
+	relPath string
+	// render is false if the page should be listed (e.g. in Site.Pages)
+	// but not written to the output, e.g. because of -since.
+	render bool
+	// modTime is the modification time of the markdown file.
+	modTime time.Time
+	// anchors are the IDs of the page's headings.
+	anchors []string
+
+	layout string
+	enc    *charsetEncoding
+	data   renderData
+	ref    *pageRef
+
+	// outputs lists the formats that the page is rendered in, from the
+	// 'outputs' frontmatter key; by default, that's just "html".
+	outputs []pageOutput
+}
+
+// pageOutput is one of the files that a page is rendered to.
+type pageOutput struct {
+	// format is the name of the output format, e.g. "html" or "amp".
+	format string
+	// layout is the layout used to render this format.
+	layout string
+	// relPath is the path of the rendered file, relative to the output
+	// root.
+	relPath string
+	// renderer renders this format.
+	renderer pageRenderer
+}
+
+// pageOutputs returns the outputs for a page with the given formats, whose
+// HTML output has the given layout and path. Formats with their own renderer
+// (see pageRenderers) are written with its extension, e.g. "post.txt". Every
+// other format than "html" is rendered with the layout of the same name, to a
+// path with the format before the extension, e.g. "post.amp.html".
+func pageOutputs(formats []string, layout, relPath string) []pageOutput {
+	if len(formats) == 0 {
+		formats = []string{"html"}
+	}
+	var ret []pageOutput
+	seen := make(map[string]bool)
+	for _, format := range formats {
+		if seen[format] {
+			continue
+		}
+		seen[format] = true
+		renderer, rext := rendererFor(format)
+		ext := filepath.Ext(relPath)
+		switch {
+		case format == "html":
+			ret = append(ret, pageOutput{format, layout, relPath, renderer})
+		case rext != "":
+			ret = append(ret, pageOutput{format, "", strings.TrimSuffix(relPath, ext) + rext, renderer})
+		default:
+			ret = append(ret, pageOutput{format, format, strings.TrimSuffix(relPath, ext) + "." + format + ext, renderer})
+		}
+	}
+	return ret
+}
+
+// pageRef is the information about a page that's available to templates
+// when listing pages, e.g. via .Site.Pages.
+type pageRef struct {
+	// Title is the page's title.
+	Title string
+	// URL is the site-absolute URL of the page.
+	URL pageURL
+	// Permalink is the absolute URL of the page, using its base URL (the
+	// page's 'baseURL' frontmatter value, or -base-url), or empty if it
+	// has no base URL.
+	Permalink string
+	// Date is the page's date, or the zero time if it has none.
+	Date time.Time
+	// Weight is the page's 'weight' frontmatter value, or zero.
+	Weight int
+	// Params is the page's merged frontmatter.
+	Params map[string]any
+	// WordCount is the number of words in the page, and ReadingTime is the
+	// estimated time to read it in minutes.
+	WordCount   int
+	ReadingTime int
+	// Authors are the page's authors.
+	Authors []*author
+	// Tasks are the page's task list items, or nil if it has none.
+	Tasks *taskList
+
+	// hasWeight is whether the page has a 'weight' frontmatter value.
+	hasWeight bool
+}
+
+// siteData is the information about the site as a whole that's available
+// to templates as .Site.
+type siteData struct {
+	Title       string
+	Description string
+
+	// ThemeColor, ThemeColorDark and ColorScheme are from the config
+	// file; the _theme-color partial renders them as meta tags.
+	ThemeColor     string
+	ThemeColorDark string
+	ColorScheme    string
+
+	// BuildTime is the time of the build: -build-time, if it's set, or
+	// else the current time. It's the "now" that dates are relative to,
+	// e.g. for timeAgo.
+	BuildTime time.Time
+
+	// Favicons links to the icons generated with -favicons, for
+	// inclusion in a page's <head>; it's empty without -favicons.
+	Favicons template.HTML
+
+	// Pages lists every page in the site, in the order defined by
+	// sortPages.
+	Pages []*pageRef
+
+	// Authors lists every author of a page, sorted by name.
+	Authors []*author
+}
+
+// sortPages sorts pages into the canonical order used by every listing:
+// pages with a 'weight' come first, in increasing order of weight, followed
+// by pages without one. Ties are broken by title, and then by URL.
+func sortPages(pages []*pageRef) {
+	sort.SliceStable(pages, func(i, j int) bool {
+		a, b := pages[i], pages[j]
+		if a.hasWeight != b.hasWeight {
+			return a.hasWeight
+		}
+		if a.Weight != b.Weight {
+			return a.Weight < b.Weight
+		}
+		if a.Title != b.Title {
+			return a.Title < b.Title
+		}
+		return a.URL < b.URL
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// pagePhase is a phase of building a page that's timed by pageProfiler.
+type pagePhase int
+
+const (
+	phaseParse     pagePhase = iota // parsing the markdown
+	phaseMarkdown                   // rendering the markdown to HTML
+	phaseSanitize                   // sanitizing the HTML
+	phaseTemplates                  // rendering the page with its layout
+	numPagePhases
+)
+
+var pagePhaseNames = [numPagePhases]string{"parse", "markdown", "sanitize", "templates"}
+
+// pageProfiler records how long each page spends in each phase, for
+// -profile-pages. A nil *pageProfiler records nothing.
+type pageProfiler struct {
+	pages map[string]*[numPagePhases]time.Duration
+}
+
+func newPageProfiler() *pageProfiler {
+	return &pageProfiler{pages: make(map[string]*[numPagePhases]time.Duration)}
+}
+
+// time starts timing a phase of building the page src; the returned
+// function must be called when the phase is complete.
+func (p *pageProfiler) time(src string, phase pagePhase) func() {
+	if p == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		t, ok := p.pages[src]
+		if !ok {
+			t = new([numPagePhases]time.Duration)
+			p.pages[src] = t
+		}
+		t[phase] += time.Since(start)
+	}
+}
+
+// report logs the n pages that took the longest in total, with the time
+// spent in each phase.
+func (p *pageProfiler) report(n int) {
+	if p == nil || len(p.pages) == 0 {
+		return
+	}
+	type entry struct {
+		src    string
+		total  time.Duration
+		phases *[numPagePhases]time.Duration
+	}
+	var entries []entry
+	for src, phases := range p.pages {
+		var total time.Duration
+		for _, d := range phases {
+			total += d
+		}
+		entries = append(entries, entry{src, total, phases})
+	}
+	slices.SortFunc(entries, func(a, b entry) int {
+		if c := cmp.Compare(b.total, a.total); c != 0 {
+			return c
+		}
+		return strings.Compare(a.src, b.src)
+	})
+
+	entries = entries[:min(n, len(entries))]
+	log.Printf("profile: the %d slowest pages:", len(entries))
+	for _, e := range entries {
+		var parts []string
+		for phase, d := range e.phases {
+			parts = append(parts, fmt.Sprintf("%s %s", pagePhaseNames[phase], d.Round(time.Microsecond)))
+		}
+		log.Printf("profile:   %12s %s (%s)", e.total.Round(time.Microsecond), e.src, strings.Join(parts, ", "))
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// readonlyGuard refuses writes into the source directory, for
+// -verify-readonly-source. A nil guard allows everything.
+type readonlyGuard struct {
+	dir  string
+	root string // dir, absolute with symlinks resolved
+}
+
+// newReadonlyGuard returns a guard for the source directory dir, or nil if
+// dir is empty.
+func newReadonlyGuard(dir string) (*readonlyGuard, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	root, err := realPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &readonlyGuard{dir: dir, root: root}, nil
+}
+
+// check returns an error if the path p, which is written to as what (e.g.
+// "the output directory"), is in the source directory. Symlinks in p are
+// resolved, even if p itself doesn't exist yet.
+func (g *readonlyGuard) check(p, what string) error {
+	if g == nil || p == "" {
+		return nil
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	// Resolve the deepest ancestor that exists, since that's what any
+	// symlinks lead to.
+	rest := ""
+	for {
+		real, err := filepath.EvalSymlinks(abs)
+		if err == nil {
+			abs = filepath.Join(real, rest)
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) || filepath.Dir(abs) == abs {
+			return err
+		}
+		rest = filepath.Join(filepath.Base(abs), rest)
+		abs = filepath.Dir(abs)
+	}
+	if within(abs, g.root) {
+		return fmt.Errorf("-verify-readonly-source: refusing to write %s %s, which is in the source directory %s", what, p, g.dir)
+	}
+	return nil
+}
+
+// guardedOutput checks every file written to a directory output with a
+// readonlyGuard before writing it.
+type guardedOutput struct {
+	outputWriter
+	root  string
+	guard *readonlyGuard
+}
+
+func (o guardedOutput) WriteFile(name string, r io.Reader, mode fs.FileMode, modTime time.Time) error {
+	if err := o.guard.check(filepath.Join(o.root, name), "output file"); err != nil {
+		return err
+	}
+	return o.outputWriter.WriteFile(name, r, mode, modTime)
+}
+
+// checkSourceUnchanged returns an error listing the files that were added,
+// modified or removed in the source directory dir since it had the hashes
+// before, as returned by hashTree.
+func checkSourceUnchanged(dir string, before map[string][sha256.Size]byte) error {
+	after, err := hashTree(dir)
+	if err != nil {
+		return fmt.Errorf("error hashing source directory: %w", err)
+	}
+	added, modified, removed := diffHashes(before, after)
+	var changes []string
+	for _, name := range added {
+		changes = append(changes, "added "+name)
+	}
+	for _, name := range modified {
+		changes = append(changes, "modified "+name)
+	}
+	for _, name := range removed {
+		changes = append(changes, "removed "+name)
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("-verify-readonly-source: the build changed the source directory %s: %s", dir, strings.Join(changes, ", "))
+	}
+	return nil
+}
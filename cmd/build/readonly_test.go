@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckSourceUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.md", "a")
+	write("b.md", "b")
+	before, err := hashTree(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSourceUnchanged(dir, before); err != nil {
+		t.Errorf("unchanged source: %v", err)
+	}
+
+	write("a.md", "changed")
+	write("c.md", "c")
+	if err := os.Remove(filepath.Join(dir, "b.md")); err != nil {
+		t.Fatal(err)
+	}
+	err = checkSourceUnchanged(dir, before)
+	if err == nil {
+		t.Fatal("changed source: got no error")
+	}
+	for _, want := range []string{"added c.md", "modified a.md", "removed b.md"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q doesn't contain %q", err, want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+)
+
+// pageRenderer renders a converted page to one of its output formats.
+type pageRenderer interface {
+	// render returns the contents of the output file for data, which is
+	// rendered with layout (if the renderer uses layouts) and encoded
+	// with enc.
+	render(g *mdGenerator, layout string, data renderData, enc *charsetEncoding) ([]byte, error)
+}
+
+// pageRenderers are the renderers for output formats that aren't rendered
+// with a layout, keyed by format, along with the extension of the files that
+// they produce. Every other format is rendered by templateRenderer.
+var pageRenderers = map[string]struct {
+	renderer pageRenderer
+	ext      string
+}{
+	"text": {textRenderer{}, ".txt"},
+}
+
+// rendererFor returns the renderer for the output format, and the extension
+// of the files that it renders, or "" if they keep the page's extension.
+func rendererFor(format string) (pageRenderer, string) {
+	if r, ok := pageRenderers[format]; ok {
+		return r.renderer, r.ext
+	}
+	return templateRenderer{}, ""
+}
+
+// templateRenderer renders a page with its layout. It's the renderer for
+// "html", and for any format without a renderer of its own.
+type templateRenderer struct{}
+
+func (templateRenderer) render(g *mdGenerator, layout string, data renderData, enc *charsetEncoding) ([]byte, error) {
+	// Render into a buffer so that we never write a partial page. With
+	// -csp, the page is rewritten before it's transcoded, since script
+	// hashes are computed over the UTF-8 text.
+	var outBuf bytes.Buffer
+	renderEnc := enc
+	if g.csp != nil {
+		renderEnc = charsetUTF8
+	}
+	if err := g.tmpls.render(layout, &outBuf, data, renderEnc); err != nil {
+		return nil, err
+	}
+	page := outBuf.Bytes()
+	if g.csp != nil {
+		page = enc.transcode(g.csp.rewrite(page))
+	}
+	return page, nil
+}
+
+// textRenderer renders a page as plain text: its title, underlined, followed
+// by the text of its content (see htmlToText).
+type textRenderer struct{}
+
+func (textRenderer) render(g *mdGenerator, layout string, data renderData, enc *charsetEncoding) ([]byte, error) {
+	var buf strings.Builder
+	if data.Title != "" {
+		buf.WriteString(data.Title)
+		buf.WriteString("\n")
+		buf.WriteString(strings.Repeat("=", len([]rune(data.Title))))
+		buf.WriteString("\n\n")
+	}
+	if content, ok := data.Content.(template.HTML); ok {
+		buf.WriteString(htmlToText(string(content)))
+	}
+	text := strings.TrimRight(buf.String(), "\n") + "\n"
+	return enc.transcode([]byte(text)), nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+)
+
+// sanitizeOptions controls how the HTML generated from markdown is
+// sanitized.
+type sanitizeOptions struct {
+	// iframeDomains lists the hosts that <iframe> elements may load
+	// content from. No iframes are allowed if it's empty.
+	iframeDomains []string
+}
+
+// classRe matches a space-separated list of class names.
+var classRe = regexp.MustCompile(`^[\w-]+( [\w-]+)*$`)
+
+// newSanitizePolicy returns the bluemonday policy used to sanitize the HTML
+// generated from markdown.
+func newSanitizePolicy(opts sanitizeOptions) *bluemonday.Policy {
+	pol := bluemonday.UGCPolicy()
+
+	// Code blocks with a title are wrapped in a <figure>; see
+	// codeBlocks.
+	pol.AllowElements("figure", "figcaption")
+
+	// Allow the classes set with attribute lists (e.g. "{.warning}"); see
+	// blockAttributes. UGCPolicy already allows ids.
+	pol.AllowAttrs("class").Matching(classRe).Globally()
+
+	// Keep the definitions of abbreviations; they're free text, which is
+	// escaped like any attribute.
+	pol.AllowAttrs("title").OnElements("abbr")
+
+	// Keep the checkboxes of task lists.
+	pol.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	pol.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`^$`)).OnElements("input")
+
+	// Keep the alignment of table columns.
+	pol.AllowAttrs("align").Matching(regexp.MustCompile(`^(left|center|right)$`)).OnElements("th", "td")
+
+	if len(opts.iframeDomains) > 0 {
+		hosts := make([]string, len(opts.iframeDomains))
+		for i, d := range opts.iframeDomains {
+			hosts[i] = regexp.QuoteMeta(strings.ToLower(d))
+		}
+		srcRe := regexp.MustCompile(`^https://(` + strings.Join(hosts, "|") + `)(/|$)`)
+
+		pol.AllowAttrs("src").Matching(srcRe).OnElements("iframe")
+		pol.AllowAttrs("width", "height").Matching(bluemonday.NumberOrPercent).OnElements("iframe")
+		pol.AllowAttrs("title").Matching(bluemonday.Paragraph).OnElements("iframe")
+		pol.AllowAttrs("allowfullscreen").OnElements("iframe")
+		pol.AllowAttrs("loading").Matching(regexp.MustCompile(`^(eager|lazy)$`)).OnElements("iframe")
+		pol.AllowAttrs("allow").Matching(regexp.MustCompile(`^[a-z-]+(; ?[a-z-]+)*;?$`)).OnElements("iframe")
+	}
+	return pol
+}
+
+// sanitizer sanitizes HTML; it's implemented by *bluemonday.Policy.
+type sanitizer interface {
+	Sanitize(s string) string
+	SanitizeReader(r io.Reader) *bytes.Buffer
+}
+
+// noSanitizer is a sanitizer that leaves HTML as it is, for pages that opt
+// out of sanitization with the 'sanitize' build directive.
+type noSanitizer struct{}
+
+func (noSanitizer) Sanitize(s string) string { return s }
+
+func (noSanitizer) SanitizeReader(r io.Reader) *bytes.Buffer {
+	var buf bytes.Buffer
+	buf.ReadFrom(r) // errors are reported by the writer
+	return &buf
+}
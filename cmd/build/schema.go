@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// frontmatterSchema declares the frontmatter keys that pages may have. It
+// maps a path glob (matched like the globs in the config file's 'defaults')
+// to the keys allowed in the pages that it matches; if several globs match a
+// page, the fields declared by more specific globs override those from less
+// specific ones. Pages that don't match any glob aren't checked.
+type frontmatterSchema map[string]map[string]fieldSchema
+
+// fieldSchema describes a single frontmatter key.
+type fieldSchema struct {
+	// Type is the type of the value; one of the keys of schemaTypes. If
+	// it's empty, any type is allowed.
+	Type string `yaml:"type"`
+	// Required is whether pages must have this key.
+	Required bool `yaml:"required"`
+}
+
+// schemaTypes maps each type name that can be used in a schema to a function
+// that reports whether a frontmatter value has that type.
+var schemaTypes = map[string]func(v any) bool{
+	"string": func(v any) bool { _, ok := v.(string); return ok },
+	"int":    func(v any) bool { _, ok := v.(int); return ok },
+	"number": func(v any) bool {
+		switch v.(type) {
+		case int, float64:
+			return true
+		}
+		return false
+	},
+	"bool": func(v any) bool { _, ok := v.(bool); return ok },
+	"list": func(v any) bool { _, ok := v.([]any); return ok },
+	"map":  func(v any) bool { _, ok := stringMap(v); return ok },
+	"date": func(v any) bool {
+		switch v.(type) {
+		case string, time.Time:
+			return true
+		}
+		return false
+	},
+}
+
+// loadSchema reads the frontmatter schema file at the given path. An empty
+// path returns a nil schema, which allows any frontmatter.
+func loadSchema(fpath string) (frontmatterSchema, error) {
+	if fpath == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	var schema frontmatterSchema
+	if err := yaml.UnmarshalStrict(data, &schema); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", fpath, err)
+	}
+	for glob, fields := range schema {
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid schema glob %q: %w", glob, err)
+		}
+		for key, field := range fields {
+			if _, ok := schemaTypes[field.Type]; field.Type != "" && !ok {
+				return nil, fmt.Errorf("schema for %q: key %q has unknown type %q; must be one of %q",
+					glob, key, field.Type, slices.Sorted(maps.Keys(schemaTypes)))
+			}
+		}
+	}
+	return schema, nil
+}
+
+// validate checks a page's frontmatter against the schema, returning an error
+// for each unknown key, value of the wrong type, and missing required key.
+// relPath is the path of the markdown file relative to the source
+// directory.
+func (s frontmatterSchema) validate(relPath string, frontmatter map[string]any) []error {
+	matched := matchGlobs(maps.Keys(s), filepath.ToSlash(relPath))
+	if len(matched) == 0 {
+		return nil
+	}
+	fields := make(map[string]fieldSchema)
+	for _, glob := range matched {
+		maps.Copy(fields, s[glob])
+	}
+
+	var errs []error
+	for _, key := range slices.Sorted(maps.Keys(frontmatter)) {
+		field, ok := fields[key]
+		switch {
+		case key == directivesKey:
+			// Build directives are always allowed.
+		case !ok:
+			errs = append(errs, fmt.Errorf("unknown frontmatter key %q", key))
+		case field.Type != "" && !schemaTypes[field.Type](frontmatter[key]):
+			errs = append(errs, fmt.Errorf("frontmatter key %q should be of type %s, not %T", key, field.Type, frontmatter[key]))
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(fields)) {
+		if _, ok := frontmatter[key]; fields[key].Required && !ok {
+			errs = append(errs, fmt.Errorf("missing required frontmatter key %q", key))
+		}
+	}
+	return errs
+}
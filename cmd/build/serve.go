@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	// Types that Go's mime package doesn't know about on every platform.
+	for ext, typ := range map[string]string{
+		".webmanifest": "application/manifest+json",
+		".json":        "application/json",
+		".wasm":        "application/wasm",
+		".svg":         "image/svg+xml",
+		".txt":         "text/plain; charset=utf-8",
+	} {
+		mime.AddExtensionType(ext, typ)
+	}
+}
+
+// serveHandler serves a built site from a directory, resolving URLs the same
+// way that the generated links expect.
+type serveHandler struct {
+	root string
+
+	// spa is whether unknown paths are served the root index.html, for
+	// client-side routers.
+	spa bool
+
+	// dirList is whether directories without an index.html are listed.
+	dirList bool
+}
+
+func (h *serveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	upath := path.Clean("/" + r.URL.Path)
+	log.Printf("serve: %s %s", r.Method, upath)
+
+	name, isDir := h.resolve(upath)
+	switch {
+	case name != "":
+		http.ServeFile(w, r, name)
+	case isDir && h.dirList:
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+			return
+		}
+		http.FileServer(http.Dir(h.root)).ServeHTTP(w, r)
+	case h.spa:
+		http.ServeFile(w, r, filepath.Join(h.root, "index.html"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// resolve returns the file that should be served for the URL path upath,
+// trying upath itself, then upath with a '.html' extension (for pages built
+// with -link-extensions=false), then upath/index.html. If no file matches,
+// it returns an empty name, and whether upath is a directory. Only files
+// under h.root are served.
+func (h *serveHandler) resolve(upath string) (name string, isDir bool) {
+	base := filepath.Join(h.root, filepath.FromSlash(upath))
+	candidates := []string{base}
+	if upath != "/" {
+		// For the root, this would be a file next to h.root.
+		candidates = append(candidates, base+".html")
+	}
+	candidates = append(candidates, filepath.Join(base, "index.html"))
+	for _, candidate := range candidates {
+		if rel, err := filepath.Rel(h.root, candidate); err != nil || (rel != "." && !filepath.IsLocal(rel)) {
+			continue
+		}
+		st, err := os.Stat(candidate)
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				log.Printf("serve: %v", err)
+			}
+			continue
+		}
+		if st.IsDir() {
+			isDir = true
+			continue
+		}
+		return candidate, false
+	}
+	return "", isDir
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServeResolve(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "public")
+	for name, content := range map[string]string{
+		"public/index.html":      "home",
+		"public/about.html":      "about",
+		"public/blog/index.html": "blog",
+		"public.html":            "outside the root",
+	} {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	h := &serveHandler{root: root}
+	for _, tt := range []struct {
+		path, want string
+		status     int
+	}{
+		{"/", "home", 200},
+		{"/about", "about", 200},
+		{"/about.html", "about", 200},
+		{"/blog/", "blog", 200},
+		{"/../public.html", "", 404},
+		{"/missing", "", 404},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.status {
+			t.Errorf("GET %s: got status %d, want %d", tt.path, w.Code, tt.status)
+			continue
+		}
+		if tt.status == 200 && w.Body.String() != tt.want {
+			t.Errorf("GET %s: got %q, want %q", tt.path, w.Body.String(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// wordsPerMinute is the reading speed used to estimate reading times.
+const wordsPerMinute = 200
+
+// wordCount returns the number of words in the text of an HTML fragment.
+func wordCount(htmlContent string) int {
+	return len(strings.Fields(htmlToText(htmlContent)))
+}
+
+// readingTime returns the estimated time to read a number of words, in whole
+// minutes; it's at least one minute for any non-empty page.
+func readingTime(words int) int {
+	if words == 0 {
+		return 0
+	}
+	return max(1, (words+wordsPerMinute/2)/wordsPerMinute)
+}
+
+// sidecarPath returns the path of the JSON sidecar for the page at relPath.
+func sidecarPath(relPath string) string {
+	return strings.TrimSuffix(relPath, ".html") + ".json"
+}
+
+// pageSidecar is the contents of a page's JSON sidecar, written with
+// -emit-json.
+type pageSidecar struct {
+	Title       string         `json:"title"`
+	Path        string         `json:"path"`
+	URL         pageURL        `json:"url"`
+	Permalink   string         `json:"permalink,omitempty"`
+	Date        string         `json:"date,omitempty"`
+	WordCount   int            `json:"word_count"`
+	ReadingTime int            `json:"reading_time"`
+	Frontmatter map[string]any `json:"frontmatter"`
+}
+
+// sidecarJSON returns the JSON sidecar for a page.
+func sidecarJSON(p *page) ([]byte, error) {
+	sc := pageSidecar{
+		Title:       p.ref.Title,
+		Path:        p.data.Path,
+		URL:         p.ref.URL,
+		Permalink:   p.ref.Permalink,
+		WordCount:   p.ref.WordCount,
+		ReadingTime: p.ref.ReadingTime,
+		Frontmatter: make(map[string]any, len(p.ref.Params)),
+	}
+	if !p.ref.Date.IsZero() {
+		sc.Date = p.ref.Date.Format(time.RFC3339)
+	}
+	for k, v := range p.ref.Params {
+		sc.Frontmatter[k] = jsonValue(v)
+	}
+	data, err := json.MarshalIndent(sc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// jsonValue converts a value decoded from YAML into one that can be encoded
+// as JSON; in particular, YAML maps can have non-string keys.
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, v := range v {
+			m[fmt.Sprint(k)] = jsonValue(v)
+		}
+		return m
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for k, v := range v {
+			m[k] = jsonValue(v)
+		}
+		return m
+	case []any:
+		s := make([]any, len(v))
+		for i, v := range v {
+			s[i] = jsonValue(v)
+		}
+		return s
+	default:
+		return v
+	}
+}
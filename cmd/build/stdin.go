@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// stdinName is the name that a page read with -stdin is converted as, as if
+// it were at the root of the source directory.
+const stdinName = "stdin.md"
+
+// renderStdin converts a single markdown page read from r, and writes it to
+// w rendered with layout, or with the layout from its frontmatter if layout
+// is empty. The page is rendered as if it were the only page on the site.
+func (g *mdGenerator) renderStdin(r io.Reader, w io.Writer, layout string) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("error reading stdin: %w", err)
+	}
+	p, err := g.convertPage(b, g.publish.now, pageOutputPath(stdinName), "<stdin>", stdinName, nil)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return errors.New("page from stdin is not published")
+	}
+	if layout == "" {
+		layout = p.layout
+	}
+	if _, ok := g.tmpls.layouts[layout]; !ok {
+		return fmt.Errorf("layout %q not found", layout)
+	}
+
+	site := g.cfg.site()
+	site.BuildTime = g.publish.now
+	site.Pages = []*pageRef{p.ref}
+	site.Authors = g.collectAuthors(site.Pages)
+	g.resolveBreadcrumbs([]*page{p})
+
+	data := p.data
+	data.Site = site
+	data.Format = "html"
+	out, err := templateRenderer{}.render(g, layout, data, p.enc)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+)
+
+// taskItem is an item of a task list ("- [x] Done"), with the 'tasklist'
+// extension.
+type taskItem struct {
+	// Text is the plain text of the item, without its checkbox.
+	Text string
+	// Done is whether the item is checked.
+	Done bool
+}
+
+// taskList is every task list item in a page, for showing its progress,
+// e.g. "7/10 tasks done".
+type taskList struct {
+	Items []taskItem
+	// Done is the number of items that are checked, out of Total.
+	Done  int
+	Total int
+}
+
+// newTaskList returns the task list for items, or nil if there are none.
+func newTaskList(items []taskItem) *taskList {
+	if len(items) == 0 {
+		return nil
+	}
+	l := &taskList{Items: items, Total: len(items)}
+	for _, item := range items {
+		if item.Done {
+			l.Done++
+		}
+	}
+	return l
+}
+
+// Percent returns the percentage of items that are done, rounded down.
+func (l *taskList) Percent() int {
+	if l == nil || l.Total == 0 {
+		return 0
+	}
+	return 100 * l.Done / l.Total
+}
+
+// collectTasks returns the task list items in doc, in document order.
+func collectTasks(doc ast.Node, source []byte) []taskItem {
+	var items []taskItem
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		box, ok := n.(*east.TaskCheckBox)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		items = append(items, taskItem{
+			Text: nodeText(box.Parent(), source),
+			Done: box.IsChecked,
+		})
+		return ast.WalkSkipChildren, nil
+	})
+	return items
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	deftemplates "github.com/andrew-d/resiliency-project/templates"
+)
+
+// templateFS is the filesystem that templates are loaded from. Files in dir
+// (if it's non-empty) override the built-in defaults with the same name,
+// which are embedded from the repository's templates directory.
+type templateFS struct {
+	dir      string
+	upper    fs.FS // nil if there is no template directory
+	defaults fs.FS
+}
+
+func newTemplateFS(dir string) *templateFS {
+	t := &templateFS{dir: dir, defaults: deftemplates.FS}
+	if dir != "" {
+		t.upper = os.DirFS(dir)
+	}
+	return t
+}
+
+func (t *templateFS) Open(name string) (fs.File, error) {
+	if t.upper != nil {
+		f, err := t.upper.Open(name)
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return f, err
+		}
+	}
+	return t.defaults.Open(name)
+}
+
+// ReadDir merges the entries of the named directory in the template
+// directory and the defaults.
+func (t *templateFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(t.defaults, name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	found := err == nil
+	if t.upper != nil {
+		upper, err := fs.ReadDir(t.upper, name)
+		switch {
+		case err == nil:
+			found = true
+			byName := make(map[string]int, len(entries))
+			for i, e := range entries {
+				byName[e.Name()] = i
+			}
+			for _, e := range upper {
+				if i, ok := byName[e.Name()]; ok {
+					entries[i] = e
+				} else {
+					entries = append(entries, e)
+				}
+			}
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, err
+		}
+	}
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// builtin reports whether the named file is one of the built-in templates,
+// rather than one from the template directory.
+func (t *templateFS) builtin(name string) bool {
+	if t.upper != nil {
+		if _, err := fs.Stat(t.upper, name); err == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// sourcePath returns a description of where the named file was loaded from,
+// for use in error messages.
+func (t *templateFS) sourcePath(name string) string {
+	if !t.builtin(name) {
+		return filepath.Join(t.dir, filepath.FromSlash(name))
+	}
+	return "(built-in) " + name
+}
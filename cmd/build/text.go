@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// htmlToText converts an HTML fragment to plain text. Entities are decoded,
+// the contents of elements such as <script> and <style> are dropped, block
+// elements are separated by blank lines, list items and <br> by newlines,
+// and other whitespace is collapsed (except inside <pre>).
+//
+// This is the one place that HTML is converted to text, so that everything
+// that needs the text of a page (word counts, excerpts, and so on) agrees on
+// what it is.
+func htmlToText(s string) string {
+	var t textBuilder
+	skip, pre := 0, 0
+	z := html.NewTokenizer(strings.NewReader(s))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return strings.TrimSpace(t.String())
+
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			if pre > 0 {
+				t.writeRaw(string(z.Text()))
+			} else {
+				t.writeCollapsed(string(z.Text()))
+			}
+
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			a := atom.Lookup(name)
+			switch a {
+			case atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Iframe:
+				if tt == html.StartTagToken {
+					skip++
+				} else if tt == html.EndTagToken && skip > 0 {
+					skip--
+				}
+				continue
+			case atom.Pre:
+				if tt == html.StartTagToken {
+					pre++
+				} else if tt == html.EndTagToken && pre > 0 {
+					pre--
+				}
+			}
+			if skip > 0 {
+				continue
+			}
+			switch {
+			case a == atom.Br:
+				t.newlines(1)
+			case lineElements[a]:
+				t.newlines(1)
+			case blockElements[a]:
+				t.newlines(2)
+			}
+		}
+	}
+}
+
+// blockElements are separated from surrounding text by a blank line.
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true,
+	atom.Blockquote: true, atom.Details: true, atom.Div: true,
+	atom.Dl: true, atom.Fieldset: true, atom.Figure: true,
+	atom.Footer: true, atom.Form: true, atom.H1: true, atom.H2: true,
+	atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Header: true, atom.Hr: true, atom.Main: true, atom.Nav: true,
+	atom.Ol: true, atom.P: true, atom.Pre: true, atom.Section: true,
+	atom.Table: true, atom.Ul: true,
+}
+
+// lineElements are put on lines of their own.
+var lineElements = map[atom.Atom]bool{
+	atom.Caption: true, atom.Dd: true, atom.Dt: true,
+	atom.Figcaption: true, atom.Li: true, atom.Summary: true,
+	atom.Tr: true,
+}
+
+// textBuilder accumulates the output of htmlToText.
+type textBuilder struct {
+	strings.Builder
+	// pending is the number of newlines to write before the next text.
+	pending int
+	// space is whether to write a space before the next text.
+	space bool
+}
+
+// newlines ensures that the next text is preceded by at least n newlines,
+// unless it's at the start of the output.
+func (t *textBuilder) newlines(n int) {
+	if t.Len() > 0 {
+		t.pending = max(t.pending, n)
+	}
+	t.space = false
+}
+
+func (t *textBuilder) writeCollapsed(s string) {
+	if strings.TrimSpace(s) == "" {
+		if s != "" && t.Len() > 0 {
+			t.space = true
+		}
+		return
+	}
+	if s[0] == ' ' || s[0] == '\t' || s[0] == '\n' || s[0] == '\r' {
+		t.space = true
+	}
+	t.flush()
+	t.WriteString(strings.Join(strings.Fields(s), " "))
+	last := s[len(s)-1]
+	t.space = last == ' ' || last == '\t' || last == '\n' || last == '\r'
+}
+
+func (t *textBuilder) writeRaw(s string) {
+	if s == "" {
+		return
+	}
+	t.flush()
+	t.WriteString(s)
+}
+
+// flush writes any pending separator.
+func (t *textBuilder) flush() {
+	switch {
+	case t.pending > 0:
+		// Preformatted text may already end with newlines.
+		out := t.String()
+		have := len(out) - len(strings.TrimRight(out, "\n"))
+		t.WriteString(strings.Repeat("\n", max(0, t.pending-have)))
+	case t.space && t.Len() > 0:
+		t.WriteByte(' ')
+	}
+	t.pending = 0
+	t.space = false
+}
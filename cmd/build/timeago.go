@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"time"
+)
+
+// timeFuncs returns the template functions for displaying times, which
+// parse dates with the site's date formats:
+//
+//	timeAgo .Date .Site.BuildTime -> "3 days ago", or "in 2 hours"
+func (c *siteConfig) timeFuncs() template.FuncMap {
+	return template.FuncMap{
+		"timeAgo": c.timeAgo,
+	}
+}
+
+// timeAgo describes the time t, which is a time.Time or a string in one of
+// the site's date formats, relative to now: "just now", "5 minutes ago",
+// "in 2 days" and so on. Months are 30 days, and years 365. The zero time,
+// e.g. the .Date of a page without one, is "".
+func (c *siteConfig) timeAgo(t any, now time.Time) (string, error) {
+	when, err := c.parseDate(t)
+	if err != nil {
+		return "", fmt.Errorf("timeAgo: %w", err)
+	}
+	if when.IsZero() {
+		return "", nil
+	}
+	d := now.Sub(when)
+	future := d < 0
+	if future {
+		d = -d
+	}
+
+	var n int
+	var unit string
+	switch {
+	case d < time.Minute:
+		return "just now", nil
+	case d < time.Hour:
+		n, unit = int(d/time.Minute), "minute"
+	case d < 24*time.Hour:
+		n, unit = int(d/time.Hour), "hour"
+	case d < 30*24*time.Hour:
+		n, unit = int(d/(24*time.Hour)), "day"
+	case d < 365*24*time.Hour:
+		n, unit = int(d/(30*24*time.Hour)), "month"
+	default:
+		n, unit = int(d/(365*24*time.Hour)), "year"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	if future {
+		return fmt.Sprintf("in %d %s", n, unit), nil
+	}
+	return fmt.Sprintf("%d %s ago", n, unit), nil
+}
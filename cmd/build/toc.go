@@ -0,0 +1,110 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+)
+
+// tocHeading is a heading collected from a page, for building its table of
+// contents.
+type tocHeading struct {
+	level int
+	id    string
+	text  string
+}
+
+// collectHeadings returns all headings in the document, in order.
+func collectHeadings(doc ast.Node, source []byte) []tocHeading {
+	var headings []tocHeading
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !ok || !entering {
+			return ast.WalkContinue, nil
+		}
+		var id string
+		if v, ok := h.AttributeString("id"); ok {
+			if b, ok := v.([]byte); ok {
+				id = string(b)
+			}
+		}
+		headings = append(headings, tocHeading{
+			level: h.Level,
+			id:    id,
+			text:  nodeText(h, source),
+		})
+		return ast.WalkSkipChildren, nil
+	})
+	return headings
+}
+
+// nodeText returns the plain text content of n and its descendants.
+func nodeText(n ast.Node, source []byte) string {
+	var sb strings.Builder
+	ast.Walk(n, func(c ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch c := c.(type) {
+		case *ast.Text:
+			sb.Write(c.Segment.Value(source))
+			if c.SoftLineBreak() || c.HardLineBreak() {
+				sb.WriteByte(' ')
+			}
+		case *ast.String:
+			sb.Write(c.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return sb.String()
+}
+
+// tocHTML renders the headings with a level of at most maxDepth as a nested
+// list of links. It returns the empty string if there are no such headings.
+func tocHTML(headings []tocHeading, maxDepth int) string {
+	var filtered []tocHeading
+	minLevel := maxDepth + 1
+	for _, h := range headings {
+		if h.level <= maxDepth && h.id != "" {
+			filtered = append(filtered, h)
+			minLevel = min(minLevel, h.level)
+		}
+	}
+	if len(filtered) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	depth := 0
+	for _, h := range filtered {
+		d := h.level - minLevel + 1
+		if d > depth {
+			for ; depth < d; depth++ {
+				sb.WriteString("<ul>\n<li>")
+			}
+		} else {
+			sb.WriteString("</li>\n")
+			for ; depth > d; depth-- {
+				sb.WriteString("</ul>\n</li>\n")
+			}
+			sb.WriteString("<li>")
+		}
+		sb.WriteString(`<a href="#` + html.EscapeString(h.id) + `">` + html.EscapeString(h.text) + `</a>`)
+	}
+	for ; depth > 0; depth-- {
+		sb.WriteString("</li>\n</ul>\n")
+	}
+	return sb.String()
+}
+
+// tocPlaceholderRe matches a paragraph containing only a table of contents
+// placeholder, either "[[TOC]]" or "[TOC]".
+var tocPlaceholderRe = regexp.MustCompile(`<p>\[\[?TOC\]\]?</p>`)
+
+// replaceTOCPlaceholders replaces every table of contents placeholder in the
+// rendered HTML with the table of contents.
+func replaceTOCPlaceholders(rendered []byte, toc string) []byte {
+	return tocPlaceholderRe.ReplaceAllLiteral(rendered, []byte(toc))
+}
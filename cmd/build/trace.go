@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log"
+	"runtime/trace"
+	"sort"
+	"time"
+)
+
+// buildTracer records how long each phase of the build, and the conversion
+// of each file, takes. A nil *buildTracer is valid and records nothing.
+type buildTracer struct {
+	// slow is the threshold above which a file's conversion time is
+	// highlighted in the report.
+	slow time.Duration
+
+	start  time.Time
+	phases []traceTiming
+	files  []traceTiming
+}
+
+type traceTiming struct {
+	name     string
+	duration time.Duration
+}
+
+func newBuildTracer(slow time.Duration) *buildTracer {
+	return &buildTracer{slow: slow, start: time.Now()}
+}
+
+// phase starts timing the named build phase; the returned function must be
+// called when the phase is complete. Phases are also recorded as regions in
+// the runtime trace, if one is being written.
+func (t *buildTracer) phase(name string) func() {
+	region := trace.StartRegion(context.Background(), name)
+	if t == nil {
+		return region.End
+	}
+	return t.record(region, &t.phases, name)
+}
+
+// file starts timing the conversion of the named file; the returned function
+// must be called when the conversion is complete.
+func (t *buildTracer) file(name string) func() {
+	region := trace.StartRegion(context.Background(), name)
+	if t == nil {
+		return region.End
+	}
+	return t.record(region, &t.files, name)
+}
+
+func (t *buildTracer) record(region *trace.Region, into *[]traceTiming, name string) func() {
+	start := time.Now()
+	return func() {
+		region.End()
+		*into = append(*into, traceTiming{name: name, duration: time.Since(start)})
+	}
+}
+
+// report logs a breakdown of the recorded timings.
+func (t *buildTracer) report() {
+	if t == nil {
+		return
+	}
+	total := time.Since(t.start)
+	log.Printf("trace: total build time %s", total.Round(time.Microsecond))
+	for _, p := range t.phases {
+		log.Printf("trace:   %-20s %12s (%5.1f%%)", p.name, p.duration.Round(time.Microsecond),
+			100*float64(p.duration)/float64(total))
+	}
+
+	if len(t.files) == 0 {
+		return
+	}
+
+	// A file may be timed more than once (e.g. when converting and then
+	// rendering it), so sum up the time spent on each one.
+	var files []traceTiming
+	index := make(map[string]int)
+	var sum time.Duration
+	for _, f := range t.files {
+		sum += f.duration
+		if i, ok := index[f.name]; ok {
+			files[i].duration += f.duration
+			continue
+		}
+		index[f.name] = len(files)
+		files = append(files, f)
+	}
+	log.Printf("trace: processed %d files in %s (average %s)", len(files),
+		sum.Round(time.Microsecond), (sum / time.Duration(len(files))).Round(time.Microsecond))
+
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].duration > files[j].duration
+	})
+	for _, f := range files {
+		if f.duration < t.slow {
+			break
+		}
+		log.Printf("trace:   SLOW %s took %s", f.name, f.duration.Round(time.Microsecond))
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// voidElements never have content or an end tag.
+var voidElements = map[atom.Atom]bool{
+	atom.Area: true, atom.Base: true, atom.Br: true, atom.Col: true,
+	atom.Embed: true, atom.Hr: true, atom.Img: true, atom.Input: true,
+	atom.Link: true, atom.Meta: true, atom.Source: true, atom.Track: true,
+	atom.Wbr: true,
+}
+
+// optionalEndElements may be left unclosed, e.g. <li> and <p>.
+var optionalEndElements = map[atom.Atom]bool{
+	atom.Body: true, atom.Caption: true, atom.Colgroup: true, atom.Dd: true,
+	atom.Dt: true, atom.Head: true, atom.Html: true, atom.Li: true,
+	atom.Optgroup: true, atom.Option: true, atom.P: true, atom.Rp: true,
+	atom.Rt: true, atom.Tbody: true, atom.Td: true, atom.Tfoot: true,
+	atom.Th: true, atom.Thead: true, atom.Tr: true,
+}
+
+// impliedEnds are the open elements that each start tag implicitly closes,
+// if they're the current element.
+var impliedEnds = map[atom.Atom][]atom.Atom{
+	atom.Li:     {atom.Li},
+	atom.Dt:     {atom.Dt, atom.Dd},
+	atom.Dd:     {atom.Dt, atom.Dd},
+	atom.Tr:     {atom.Td, atom.Th, atom.Tr},
+	atom.Td:     {atom.Td, atom.Th},
+	atom.Th:     {atom.Td, atom.Th},
+	atom.Option: {atom.Option},
+}
+
+// htmlProblems checks the HTML document b for mismatched tags: end tags
+// that don't match an open element, elements left unclosed that must be
+// closed, and self-closing non-void elements. It returns a description of
+// each problem, with its line number.
+//
+// It isn't a full validator, but catches the mistakes that templates
+// usually make, which browsers silently repair.
+func htmlProblems(b []byte) []string {
+	var (
+		problems []string
+		open     []html.Token
+		lines    []int // the line that each element in open started on
+		foreign  int   // depth of <svg> and <math> elements
+		line     = 1
+	)
+	z := html.NewTokenizer(bytes.NewReader(b))
+	for {
+		tt := z.Next()
+		start := line
+		line += bytes.Count(z.Raw(), []byte("\n"))
+		if tt == html.ErrorToken {
+			if err := z.Err(); !errors.Is(err, io.EOF) {
+				problems = append(problems, fmt.Sprintf("line %d: %v", start, err))
+			}
+			break
+		}
+		if tt != html.StartTagToken && tt != html.EndTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+		tok := z.Token()
+		switch tt {
+		case html.SelfClosingTagToken:
+			if foreign == 0 && !voidElements[tok.DataAtom] {
+				problems = append(problems, fmt.Sprintf("line %d: self-closing <%s/> isn't allowed; it's left open", start, tok.Data))
+			}
+		case html.StartTagToken:
+			if foreign == 0 && voidElements[tok.DataAtom] {
+				continue
+			}
+			if foreign == 0 {
+				for len(open) > 0 && slices.Contains(impliedEnds[tok.DataAtom], open[len(open)-1].DataAtom) {
+					open, lines = open[:len(open)-1], lines[:len(lines)-1]
+				}
+				if len(open) > 0 && open[len(open)-1].DataAtom == atom.P && blockElements[tok.DataAtom] {
+					open, lines = open[:len(open)-1], lines[:len(lines)-1]
+				}
+			}
+			if tok.DataAtom == atom.Svg || tok.DataAtom == atom.Math {
+				foreign++
+			}
+			open = append(open, tok)
+			lines = append(lines, start)
+		case html.EndTagToken:
+			if foreign == 0 && voidElements[tok.DataAtom] {
+				problems = append(problems, fmt.Sprintf("line %d: end tag </%s> for a void element", start, tok.Data))
+				continue
+			}
+			i := len(open) - 1
+			for i >= 0 && open[i].Data != tok.Data {
+				i--
+			}
+			if i < 0 {
+				problems = append(problems, fmt.Sprintf("line %d: unexpected end tag </%s>", start, tok.Data))
+				continue
+			}
+			for j := len(open) - 1; j > i; j-- {
+				if foreign == 0 && !optionalEndElements[open[j].DataAtom] {
+					problems = append(problems, fmt.Sprintf("line %d: <%s> from line %d is closed by </%s>", start, open[j].Data, lines[j], tok.Data))
+				}
+			}
+			if tok.DataAtom == atom.Svg || tok.DataAtom == atom.Math {
+				foreign--
+			}
+			open, lines = open[:i], lines[:i]
+		}
+	}
+	for i, tok := range open {
+		if !optionalEndElements[tok.DataAtom] {
+			problems = append(problems, fmt.Sprintf("line %d: <%s> is never closed", lines[i], tok.Data))
+		}
+	}
+	return problems
+}
+
+// checkHTMLOutput reports any problems found by htmlProblems in the page
+// written to relPath as a warning, or in strict mode an error.
+func (g *mdGenerator) checkHTMLOutput(relPath string, b []byte) error {
+	problems := htmlProblems(b)
+	if len(problems) == 0 {
+		return nil
+	}
+	return g.warn(fmt.Errorf("invalid HTML in %s: %s", relPath, strings.Join(problems, "; ")))
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// defaultVariantPath is the output path of a variant that doesn't set one.
+const defaultVariantPath = "{path}.{name}.html"
+
+// pageVariant configures an extra rendering of each page that it matches
+// with another layout, e.g. a printable version. It's an entry in the
+// 'variants' map of the config file, keyed by the variant's name, which is
+// the .Format that it's rendered with.
+type pageVariant struct {
+	// Match is a glob, as in 'defaults', matching the source paths of the
+	// pages that have the variant.
+	Match string `yaml:"match"`
+	// Layout is the layout that the variant is rendered with.
+	Layout string `yaml:"layout"`
+	// Path is the output path of the variant, in which "{path}" is the
+	// page's output path without its extension (or, for a section index
+	// other than the home page, its directory) and "{name}" is the name
+	// of the variant.
+	Path string `yaml:"path"`
+}
+
+// validate checks the variant's configuration, and fills in defaults.
+func (v *pageVariant) validate(name string) error {
+	if name == "" || name == "html" || strings.ContainsAny(name, `/\.`) {
+		return fmt.Errorf("invalid variant name %q", name)
+	}
+	if v.Match == "" {
+		return fmt.Errorf("variant %q has no 'match' glob", name)
+	}
+	if _, err := path.Match(v.Match, ""); err != nil {
+		return fmt.Errorf("variant %q has invalid glob %q: %w", name, v.Match, err)
+	}
+	if v.Layout == "" {
+		return fmt.Errorf("variant %q has no layout", name)
+	}
+	if v.Path == "" {
+		v.Path = defaultVariantPath
+	}
+	if !strings.Contains(v.Path, "{path}") {
+		return fmt.Errorf("variant %q has output path %q, which doesn't contain {path}", name, v.Path)
+	}
+	return nil
+}
+
+// outputPath returns the output path of the variant, named name, of the page
+// rendered to relPath.
+func (v *pageVariant) outputPath(name, relPath string) (string, error) {
+	base := strings.TrimSuffix(filepath.ToSlash(relPath), filepath.Ext(relPath))
+	// The home page has no directory to stand in for it, so it keeps its
+	// own name, e.g. "index.print.html".
+	if path.Base(base) == "index" && path.Dir(base) != "." {
+		base = path.Dir(base)
+	}
+	p := strings.NewReplacer("{path}", base, "{name}", name).Replace(v.Path)
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if !filepath.IsLocal(filepath.FromSlash(p)) {
+		return "", fmt.Errorf("variant %q has invalid output path %q", name, p)
+	}
+	return filepath.FromSlash(p), nil
+}
+
+// pageVariants returns the outputs for the variants that match the page at
+// relSrc in the source directory, which is rendered to relPath, in order of
+// name.
+func (c *siteConfig) pageVariants(relSrc, relPath string) ([]pageOutput, error) {
+	var ret []pageOutput
+	for _, name := range slices.Sorted(maps.Keys(c.Variants)) {
+		v := c.Variants[name]
+		if ok, _ := path.Match(v.Match, filepath.ToSlash(relSrc)); !ok {
+			continue
+		}
+		p, err := v.outputPath(name, relPath)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, pageOutput{name, v.Layout, p, templateRenderer{}})
+	}
+	return ret, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestVariantOutputPath(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, relPath, want string
+	}{
+		{defaultVariantPath, "blog/post.html", "blog/post.print.html"},
+		{defaultVariantPath, "blog/index.html", "blog.print.html"},
+		{defaultVariantPath, "index.html", "index.print.html"},
+		{defaultVariantPath, "index", "index.print.html"},
+		{"{path}/print.html", "blog/post.html", "blog/post/print.html"},
+		{"{path}/print.html", "blog/index.html", "blog/print.html"},
+		{"{path}/print.html", "index.html", "index/print.html"},
+	} {
+		v := &pageVariant{Path: tt.pattern}
+		got, err := v.outputPath("print", filepath.FromSlash(tt.relPath))
+		if err != nil {
+			t.Errorf("%s with %s: %v", tt.relPath, tt.pattern, err)
+			continue
+		}
+		if got != filepath.FromSlash(tt.want) {
+			t.Errorf("%s with %s: got %s, want %s", tt.relPath, tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -6,11 +6,11 @@ require (
 	github.com/microcosm-cc/bluemonday v1.0.27
 	github.com/yuin/goldmark v1.7.8
 	github.com/yuin/goldmark-meta v1.1.0
+	golang.org/x/net v0.26.0
+	gopkg.in/yaml.v2 v2.3.0
 )
 
 require (
 	github.com/aymerick/douceur v0.2.0 // indirect
 	github.com/gorilla/css v1.0.1 // indirect
-	golang.org/x/net v0.26.0 // indirect
-	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
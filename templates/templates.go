@@ -0,0 +1,11 @@
+// Package templates holds the default layouts and partials. They're used as
+// the site's templates when building from the repository root, and embedded
+// in the build command as its built-in templates.
+package templates
+
+import "embed"
+
+// FS contains the layouts and partials directories.
+//
+//go:embed all:layouts all:partials
+var FS embed.FS